@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultAccessLogMaxBytes is the default size at which an AccessLogger
+// writing to a file rotates it, used when NewAccessLogger is given a
+// non-positive maxBytes.
+const DefaultAccessLogMaxBytes = 100 * 1024 * 1024 // 100MiB
+
+// AccessLogEntry is one JSON line written by an AccessLogger, capturing
+// the details of a single client request as lb finished serving it.
+type AccessLogEntry struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	ClientIP   string    `json:"client_ip"`
+	Backend    string    `json:"backend"`
+	Status     int       `json:"status"`
+	Retries    int       `json:"retries"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+// AccessLogger writes one JSON line per AccessLogEntry to stdout or to a
+// file that rotates once it grows past maxBytes. Rotation keeps a single
+// prior generation, renamed with a ".1" suffix; anything older is
+// overwritten, matching other operational logs in this project that don't
+// try to be a full log-archiving solution.
+type AccessLogger struct {
+	mux      sync.Mutex
+	w        *os.File
+	path     string // empty means w is os.Stdout and is never rotated
+	maxBytes int64
+	curBytes int64
+}
+
+// NewAccessLogger returns an AccessLogger writing to path, rotating once
+// the file exceeds maxBytes (or DefaultAccessLogMaxBytes if maxBytes <=
+// 0). An empty path logs to stdout, which is never rotated.
+func NewAccessLogger(path string, maxBytes int64) (*AccessLogger, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultAccessLogMaxBytes
+	}
+	if path == "" {
+		return &AccessLogger{w: os.Stdout, maxBytes: maxBytes}, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening access log %s: %w", path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("stat access log %s: %w", path, err)
+	}
+	return &AccessLogger{w: f, path: path, maxBytes: maxBytes, curBytes: info.Size()}, nil
+}
+
+// Log writes entry as a single JSON line, rotating the underlying file
+// first if it has grown past maxBytes.
+func (a *AccessLogger) Log(entry AccessLogEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if a.path != "" && a.curBytes+int64(len(line)) > a.maxBytes {
+		if err := a.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "access log: rotation failed: %v\n", err)
+		}
+	}
+
+	n, _ := a.w.Write(line)
+	a.curBytes += int64(n)
+}
+
+// rotate renames the current file to path+".1" (clobbering any previous
+// one) and reopens path fresh. Caller must hold a.mux.
+func (a *AccessLogger) rotate() error {
+	a.w.Close()
+	if err := os.Rename(a.path, a.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	a.w = f
+	a.curBytes = 0
+	return nil
+}
+
+// Close closes the underlying file. A no-op for a stdout logger.
+func (a *AccessLogger) Close() error {
+	if a.path == "" {
+		return nil
+	}
+	return a.w.Close()
+}
+
+// accessLogger is the process-wide access logger. It defaults to logging
+// to stdout so lb produces access logs out of the box; -access-log-file
+// points it at a rotated file instead.
+var accessLogger = &AccessLogger{w: os.Stdout}
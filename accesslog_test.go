@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that Log writes one JSON line per entry with the expected fields.
+func TestAccessLoggerWritesJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	logger, err := NewAccessLogger(path, 0)
+	if err != nil {
+		t.Fatalf("NewAccessLogger: %v", err)
+	}
+	defer logger.Close()
+
+	logger.Log(AccessLogEntry{
+		Time:       time.Now(),
+		Method:     "GET",
+		Path:       "/foo",
+		ClientIP:   "10.0.0.1",
+		Backend:    "backend-a",
+		Status:     200,
+		Retries:    1,
+		DurationMS: 12.5,
+	})
+
+	lines := readLines(t, path)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %d", len(lines))
+	}
+	var entry AccessLogEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("decoding entry: %v", err)
+	}
+	if entry.Backend != "backend-a" || entry.Retries != 1 || entry.Status != 200 {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+}
+
+// Test that the log file rotates to a ".1" suffix once it exceeds maxBytes,
+// and that logging continues into a fresh file afterward.
+func TestAccessLoggerRotatesOnSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	logger, err := NewAccessLogger(path, 200)
+	if err != nil {
+		t.Fatalf("NewAccessLogger: %v", err)
+	}
+	defer logger.Close()
+
+	for i := 0; i < 20; i++ {
+		logger.Log(AccessLogEntry{Method: "GET", Path: "/foo", Backend: "backend-a"})
+	}
+
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("expected rotated file %s.1 to exist: %v", path, err)
+	}
+	if lines := readLines(t, path); len(lines) == 0 {
+		t.Error("expected the active log file to still have entries after rotation")
+	}
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("opening %s: %v", path, err)
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultDrainTimeout bounds how long DELETE /admin/backends/{id} waits for
+// a backend's in-flight connections to finish before removing it anyway.
+const DefaultDrainTimeout = 30 * time.Second
+
+// AdminServer exposes an HTTP API for inspecting and reconfiguring a
+// ServerPool at runtime, plus a Prometheus /metrics endpoint. It's meant
+// to be served on a separate listener from the proxy traffic itself.
+type AdminServer struct {
+	pool *ServerPool
+	mux  *http.ServeMux
+}
+
+// NewAdminServer builds an AdminServer fronting pool.
+func NewAdminServer(pool *ServerPool) *AdminServer {
+	a := &AdminServer{pool: pool, mux: http.NewServeMux()}
+	a.mux.HandleFunc("/admin/backends", a.handleBackends)
+	a.mux.HandleFunc("/admin/backends/", a.handleBackendByID)
+	a.mux.HandleFunc("/admin/stats", a.handleStats)
+	a.mux.Handle("/metrics", metrics.Handler(pool))
+	return a
+}
+
+// ServeHTTP makes AdminServer an http.Handler.
+func (a *AdminServer) ServeHTTP(w http.ResponseWriter, r *http.Request) { a.mux.ServeHTTP(w, r) }
+
+// ListenAndServe starts the admin API on addr.
+func (a *AdminServer) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, a)
+}
+
+// backendView is the JSON shape returned for a single backend.
+type backendView struct {
+	ID             string `json:"id"`
+	URL            string `json:"url"`
+	Alive          bool   `json:"alive"`
+	Draining       bool   `json:"draining"`
+	Weight         int    `json:"weight"`
+	ActiveConns    int64  `json:"active_conns"`
+	TotalRequests  int64  `json:"total_requests"`
+	FailedRequests int64  `json:"failed_requests"`
+	CircuitState   string `json:"circuit_state"`
+}
+
+func newBackendView(b *Backend) backendView {
+	b.Stats.mux.Lock()
+	total := b.Stats.TotalRequests
+	failed := b.Stats.FailedRequests
+	b.Stats.mux.Unlock()
+
+	return backendView{
+		ID:             b.ID,
+		URL:            b.URL.String(),
+		Alive:          b.IsAlive(),
+		Draining:       b.IsDraining(),
+		Weight:         b.Weight,
+		ActiveConns:    b.ConnectionCount(),
+		TotalRequests:  total,
+		FailedRequests: failed,
+		CircuitState:   b.Stats.State().String(),
+	}
+}
+
+// handleBackends serves GET /admin/backends (list) and POST /admin/backends
+// (add).
+func (a *AdminServer) handleBackends(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		views := []backendView{}
+		for _, b := range a.pool.Backends() {
+			views = append(views, newBackendView(b))
+		}
+		writeJSON(w, http.StatusOK, views)
+
+	case http.MethodPost:
+		var c BackendConfig
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if c.URL == "" {
+			http.Error(w, "url is required", http.StatusBadRequest)
+			return
+		}
+		b, err := c.buildBackend()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		probeCfg, err := c.HealthCheck.probeConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, exists := a.pool.Backend(b.ID); exists {
+			http.Error(w, "backend already exists: "+b.ID, http.StatusConflict)
+			return
+		}
+		a.pool.AddBackend(b)
+		if hc := a.pool.HealthChecker(); hc != nil {
+			hc.Configure(b, probeCfg)
+		}
+		writeJSON(w, http.StatusCreated, newBackendView(b))
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleBackendByID serves DELETE /admin/backends/{id} (drain and remove)
+// and POST /admin/backends/{id}/drain (toggle drain mode).
+func (a *AdminServer) handleBackendByID(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/admin/backends/")
+	id, action, hasAction := strings.Cut(rest, "/")
+	if id == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if hasAction {
+		if action != "drain" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		a.handleToggleDrain(w, r, id)
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	a.handleRemove(w, r, id)
+}
+
+func (a *AdminServer) handleToggleDrain(w http.ResponseWriter, r *http.Request, id string) {
+	b, ok := a.pool.Backend(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var body struct {
+		Draining *bool `json:"draining"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	draining := !b.IsDraining()
+	if body.Draining != nil {
+		draining = *body.Draining
+	}
+	b.SetDraining(draining)
+	writeJSON(w, http.StatusOK, newBackendView(b))
+}
+
+func (a *AdminServer) handleRemove(w http.ResponseWriter, r *http.Request, id string) {
+	if _, ok := a.pool.Backend(id); !ok {
+		http.NotFound(w, r)
+		return
+	}
+	a.pool.Drain(id, DefaultDrainTimeout)
+	a.pool.RemoveBackend(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// poolStats is the aggregate view served at GET /admin/stats.
+type poolStats struct {
+	Backends       int   `json:"backends"`
+	AliveBackends  int   `json:"alive_backends"`
+	TotalRequests  int64 `json:"total_requests"`
+	FailedRequests int64 `json:"failed_requests"`
+	ActiveConns    int64 `json:"active_conns"`
+}
+
+func (a *AdminServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var stats poolStats
+	for _, b := range a.pool.Backends() {
+		stats.Backends++
+		if b.IsAlive() {
+			stats.AliveBackends++
+		}
+		stats.ActiveConns += b.ConnectionCount()
+
+		b.Stats.mux.Lock()
+		stats.TotalRequests += b.Stats.TotalRequests
+		stats.FailedRequests += b.Stats.FailedRequests
+		b.Stats.mux.Unlock()
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newAdminTestBackend(t *testing.T, id, rawUrl string) *Backend {
+	t.Helper()
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		t.Fatalf("parsing url: %v", err)
+	}
+	return &Backend{ID: id, URL: u, Alive: true}
+}
+
+// Test that GET /admin/backends lists every backend in the pool.
+func TestAdminListBackends(t *testing.T) {
+	pool := &ServerPool{}
+	pool.AddBackend(newAdminTestBackend(t, "a", "http://localhost:9101"))
+	pool.AddBackend(newAdminTestBackend(t, "b", "http://localhost:9102"))
+	admin := NewAdminServer(pool)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/backends", nil)
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var views []backendView
+	if err := json.Unmarshal(w.Body.Bytes(), &views); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if len(views) != 2 {
+		t.Errorf("expected 2 backends, got %d", len(views))
+	}
+}
+
+// Test that POST /admin/backends adds a new backend to the pool.
+func TestAdminAddBackend(t *testing.T) {
+	pool := &ServerPool{}
+	admin := NewAdminServer(pool)
+
+	body := `{"id":"new","url":"http://localhost:9103","weight":2}`
+	req := httptest.NewRequest(http.MethodPost, "/admin/backends", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, ok := pool.Backend("new"); !ok {
+		t.Error("expected backend \"new\" to be registered with the pool")
+	}
+}
+
+// Test that POST /admin/backends registers the new backend with the
+// pool's HealthChecker, so backends added at runtime get actively probed
+// instead of sitting unchecked forever.
+func TestAdminAddBackendConfiguresHealthChecker(t *testing.T) {
+	pool := &ServerPool{}
+	hc := NewHealthChecker()
+	pool.SetHealthChecker(hc)
+	admin := NewAdminServer(pool)
+
+	body := `{"id":"new","url":"http://localhost:9106"}`
+	req := httptest.NewRequest(http.MethodPost, "/admin/backends", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(hc.targets) != 1 {
+		t.Fatalf("expected 1 backend registered with the health checker, got %d", len(hc.targets))
+	}
+}
+
+// Test that DELETE /admin/backends/{id} removes the backend.
+func TestAdminRemoveBackend(t *testing.T) {
+	pool := &ServerPool{}
+	pool.AddBackend(newAdminTestBackend(t, "gone", "http://localhost:9104"))
+	admin := NewAdminServer(pool)
+
+	req := httptest.NewRequest(http.MethodDelete, "/admin/backends/gone", nil)
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if _, ok := pool.Backend("gone"); ok {
+		t.Error("expected backend \"gone\" to be removed from the pool")
+	}
+}
+
+// Test that POST /admin/backends/{id}/drain toggles drain mode without
+// removing the backend.
+func TestAdminToggleDrain(t *testing.T) {
+	pool := &ServerPool{}
+	pool.AddBackend(newAdminTestBackend(t, "d", "http://localhost:9105"))
+	admin := NewAdminServer(pool)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/backends/d/drain", strings.NewReader(`{"draining":true}`))
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	b, _ := pool.Backend("d")
+	if !b.IsDraining() {
+		t.Error("expected backend to be draining")
+	}
+}
+
+// Test that backends can be added and removed concurrently with traffic
+// reading the pool, without data races or lost updates (run with -race).
+func TestAdminConcurrentAddRemoveUnderTraffic(t *testing.T) {
+	pool := &ServerPool{}
+	for i := 0; i < 5; i++ {
+		pool.AddBackend(newAdminTestBackend(t, string(rune('a'+i)), "http://localhost:920"+string(rune('0'+i))))
+	}
+	admin := NewAdminServer(pool)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	// Simulate request traffic reading the pool concurrently with admin
+	// mutations below.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				pool.GetNextPeerForRequest(nil)
+			}
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		id := "extra"
+		body := `{"id":"` + id + `","url":"http://localhost:9300"}`
+		req := httptest.NewRequest(http.MethodPost, "/admin/backends", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+
+		req = httptest.NewRequest(http.MethodDelete, "/admin/backends/"+id, nil)
+		w = httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+	}
+
+	close(stop)
+	wg.Wait()
+
+	if _, ok := pool.Backend("extra"); ok {
+		t.Error("expected transient backend to end up removed")
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitState is the passive failure-tracking state of a backend.
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BackendStats holds the scrape-able counters and circuit-breaker state for
+// a backend, updated by the request path and the health checker.
+type BackendStats struct {
+	mux sync.Mutex
+
+	TotalRequests  int64
+	FailedRequests int64
+	LastCheck      time.Time
+
+	consecutiveFailures int
+	state               CircuitState
+	openedAt            time.Time
+	failureWindow       []time.Time
+}
+
+// State returns the backend's current circuit-breaker state.
+func (s *BackendStats) State() CircuitState {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.state
+}
+
+// ConsecutiveFailures returns the number of passive failures recorded back
+// to back, reset on the first success.
+func (s *BackendStats) ConsecutiveFailures() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.consecutiveFailures
+}
+
+// CircuitBreakerConfig controls passive failure tracking: after
+// FailureThreshold failures land within Window, the backend's circuit opens
+// for Cooldown, then allows a single half-open probe request before fully
+// reinstating the backend.
+type CircuitBreakerConfig struct {
+	FailureThreshold int
+	Window           time.Duration
+	Cooldown         time.Duration
+}
+
+func (c *CircuitBreakerConfig) setDefaults() {
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = 5
+	}
+	if c.Window <= 0 {
+		c.Window = 10 * time.Second
+	}
+	if c.Cooldown <= 0 {
+		c.Cooldown = 30 * time.Second
+	}
+}
+
+// CircuitBreaker tracks passive request outcomes per backend, independently
+// of the active HealthChecker, and opens a backend's circuit when it fails
+// too often too fast.
+type CircuitBreaker struct {
+	cfg CircuitBreakerConfig
+}
+
+// NewCircuitBreaker returns a CircuitBreaker, applying defaults to any
+// unset fields in cfg.
+func NewCircuitBreaker(cfg CircuitBreakerConfig) *CircuitBreaker {
+	cfg.setDefaults()
+	return &CircuitBreaker{cfg: cfg}
+}
+
+// Allow reports whether a request may be dispatched to b: true when the
+// circuit is closed, or when the cooldown has elapsed and this is the
+// single half-open probe request.
+func (cb *CircuitBreaker) Allow(b *Backend) bool {
+	now := time.Now()
+	s := &b.Stats
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	switch s.state {
+	case CircuitOpen:
+		if now.Sub(s.openedAt) < cb.cfg.Cooldown {
+			return false
+		}
+		// Cooldown elapsed: let exactly one probe request through and mark
+		// the circuit half-open so concurrent requests don't all pile onto
+		// the still-unproven backend. RecordSuccess closes it for real;
+		// RecordFailure sends it back to open.
+		s.state = CircuitHalfOpen
+		s.openedAt = now
+		return true
+	case CircuitHalfOpen:
+		// The single probe request is already in flight; hold everyone
+		// else back until it resolves.
+		return false
+	default:
+		return true
+	}
+}
+
+// RecordSuccess clears failure tracking and closes the circuit.
+func (cb *CircuitBreaker) RecordSuccess(b *Backend) {
+	s := &b.Stats
+	s.mux.Lock()
+	s.consecutiveFailures = 0
+	s.failureWindow = nil
+	s.state = CircuitClosed
+	s.mux.Unlock()
+}
+
+// RecordFailure records a failed request and opens the circuit once
+// FailureThreshold failures have landed inside Window.
+func (cb *CircuitBreaker) RecordFailure(b *Backend) {
+	now := time.Now()
+	s := &b.Stats
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.FailedRequests++
+	s.consecutiveFailures++
+
+	if s.state == CircuitHalfOpen {
+		// The probe request that earned this backend a half-open trial
+		// failed: send it straight back to open without waiting for the
+		// failure window to fill up again.
+		s.state = CircuitOpen
+		s.openedAt = now
+		return
+	}
+
+	cutoff := now.Add(-cb.cfg.Window)
+	window := s.failureWindow[:0]
+	for _, t := range s.failureWindow {
+		if t.After(cutoff) {
+			window = append(window, t)
+		}
+	}
+	s.failureWindow = append(window, now)
+
+	if len(s.failureWindow) >= cb.cfg.FailureThreshold {
+		s.state = CircuitOpen
+		s.openedAt = now
+	}
+}
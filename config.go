@@ -0,0 +1,287 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the top-level shape of a backend config file, loaded by
+// LoadConfig and applied to a ServerPool via Reconcile.
+type Config struct {
+	Backends []BackendConfig `yaml:"backends" json:"backends"`
+}
+
+// BackendConfig describes one upstream in a config file.
+type BackendConfig struct {
+	ID          string             `yaml:"id" json:"id"`
+	URL         string             `yaml:"url" json:"url"`
+	Weight      int                `yaml:"weight" json:"weight"`
+	MaxConns    int64              `yaml:"max_conns" json:"max_conns"`
+	HealthCheck *HealthCheckConfig `yaml:"health_check" json:"health_check"`
+	TLS         *BackendTLSConfig  `yaml:"tls" json:"tls"`
+
+	// Type selects the backend's wire protocol: "http" (the default) or
+	// "fastcgi". FastCGI is only valid alongside a FastCGI block.
+	Type    string             `yaml:"type" json:"type"`
+	FastCGI *FastCGIConfigFile `yaml:"fastcgi" json:"fastcgi"`
+}
+
+// FastCGIConfigFile is the config-file representation of a FastCGIConfig.
+// URL's scheme picks the dial network ("unix" for a socket path, anything
+// else dials TCP against the host:port); Network/Address override that
+// when set explicitly.
+type FastCGIConfigFile struct {
+	Network   string            `yaml:"network" json:"network"`
+	Address   string            `yaml:"address" json:"address"`
+	Root      string            `yaml:"root" json:"root"`
+	SplitPath []string          `yaml:"split_path" json:"split_path"`
+	Env       map[string]string `yaml:"env" json:"env"`
+}
+
+// HealthCheckConfig is the config-file representation of a ProbeConfig;
+// durations are parsed with time.ParseDuration so files can write "5s"
+// rather than raw nanoseconds.
+type HealthCheckConfig struct {
+	Type               string `yaml:"type" json:"type"`
+	Interval           string `yaml:"interval" json:"interval"`
+	Timeout            string `yaml:"timeout" json:"timeout"`
+	Path               string `yaml:"path" json:"path"`
+	HealthyThreshold   int    `yaml:"healthy_threshold" json:"healthy_threshold"`
+	UnhealthyThreshold int    `yaml:"unhealthy_threshold" json:"unhealthy_threshold"`
+}
+
+// BackendTLSConfig configures the TLS client used when proxying to an
+// https:// backend.
+type BackendTLSConfig struct {
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" json:"insecure_skip_verify"`
+	ServerName         string `yaml:"server_name" json:"server_name"`
+}
+
+// id returns the backend's configured ID, defaulting to its URL when unset.
+func (c *BackendConfig) id() string {
+	if c.ID != "" {
+		return c.ID
+	}
+	return c.URL
+}
+
+// buildBackend constructs a fresh Backend from c.
+func (c *BackendConfig) buildBackend() (*Backend, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backend url %q: %w", c.URL, err)
+	}
+
+	b := &Backend{
+		ID:       c.id(),
+		URL:      u,
+		Alive:    true,
+		Weight:   c.Weight,
+		MaxConns: c.MaxConns,
+	}
+
+	switch strings.ToLower(c.Type) {
+	case "", "http":
+		proxy := httputil.NewSingleHostReverseProxy(u)
+		if c.TLS != nil {
+			proxy.Transport = &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: c.TLS.InsecureSkipVerify,
+					ServerName:         c.TLS.ServerName,
+				},
+			}
+		}
+		b.ReverseProxy = proxy
+
+	case "fastcgi":
+		if c.FastCGI == nil {
+			return nil, fmt.Errorf("backend %q: type fastcgi requires a fastcgi config block", c.id())
+		}
+		network, address := fastCGIDialTarget(u)
+		if c.FastCGI.Network != "" {
+			network = c.FastCGI.Network
+		}
+		if c.FastCGI.Address != "" {
+			address = c.FastCGI.Address
+		}
+		b.Transport = NewFastCGIBackend(FastCGIConfig{
+			Network:   network,
+			Address:   address,
+			Root:      c.FastCGI.Root,
+			SplitPath: c.FastCGI.SplitPath,
+			Env:       c.FastCGI.Env,
+		})
+
+	default:
+		return nil, fmt.Errorf("backend %q: unknown type %q", c.id(), c.Type)
+	}
+
+	return b, nil
+}
+
+// fastCGIDialTarget derives the default dial network and address for a
+// FastCGI backend from its URL: a unix:// URL dials its path as a Unix
+// socket, anything else dials its host:port over TCP.
+func fastCGIDialTarget(u *url.URL) (network, address string) {
+	if u.Scheme == "unix" {
+		return "unix", u.Path
+	}
+	return "tcp", u.Host
+}
+
+// probeConfig converts a config file's HealthCheckConfig into the
+// ProbeConfig the HealthChecker expects, applying ProbeConfig's own
+// defaults for anything left blank.
+func (c *HealthCheckConfig) probeConfig() (ProbeConfig, error) {
+	if c == nil {
+		return ProbeConfig{Type: ProbeTCP}, nil
+	}
+	cfg := ProbeConfig{
+		Path:               c.Path,
+		HealthyThreshold:   c.HealthyThreshold,
+		UnhealthyThreshold: c.UnhealthyThreshold,
+	}
+	switch strings.ToLower(c.Type) {
+	case "", "tcp":
+		cfg.Type = ProbeTCP
+	case "http":
+		cfg.Type = ProbeHTTP
+	default:
+		return ProbeConfig{}, fmt.Errorf("unknown health check type %q", c.Type)
+	}
+	if d, err := time.ParseDuration(c.Interval); err == nil {
+		cfg.Interval = d
+	}
+	if d, err := time.ParseDuration(c.Timeout); err == nil {
+		cfg.Timeout = d
+	}
+	return cfg, nil
+}
+
+// LoadConfig reads and parses a backend config file. The format (YAML or
+// JSON) is picked from the file extension; .yaml and .yml are parsed as
+// YAML, everything else as JSON.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+
+	var cfg Config
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &cfg)
+	default:
+		err = json.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// ConfigWatcher watches a backend config file for changes and reconciles
+// them into a ServerPool, debouncing bursts of filesystem events (editors
+// commonly write-then-rename, firing several events per save) into a
+// single reload.
+type ConfigWatcher struct {
+	path  string
+	pool  *ServerPool
+	watch *fsnotify.Watcher
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewConfigWatcher prepares a ConfigWatcher for path. Call Start to begin
+// watching.
+func NewConfigWatcher(path string, pool *ServerPool) (*ConfigWatcher, error) {
+	watch, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("creating config watcher: %w", err)
+	}
+	// Watch the containing directory rather than the file itself: editors
+	// and config-management tools commonly replace the file via
+	// rename-into-place, which fsnotify can't see if it's watching the old
+	// inode directly.
+	if err := watch.Add(filepath.Dir(path)); err != nil {
+		watch.Close()
+		return nil, fmt.Errorf("watching %s: %w", filepath.Dir(path), err)
+	}
+	return &ConfigWatcher{
+		path:  path,
+		pool:  pool,
+		watch: watch,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}, nil
+}
+
+// Start launches the watcher's reload loop in the background.
+func (w *ConfigWatcher) Start() error {
+	go w.run()
+	return nil
+}
+
+// Stop shuts the watcher down, releasing its filesystem handle.
+func (w *ConfigWatcher) Stop() error {
+	close(w.stop)
+	<-w.done
+	return w.watch.Close()
+}
+
+func (w *ConfigWatcher) run() {
+	defer close(w.done)
+
+	const debounce = 250 * time.Millisecond
+	var timer *time.Timer
+	reload := func() {
+		cfg, err := LoadConfig(w.path)
+		if err != nil {
+			log.Printf("config reload: %v (keeping previous config)", err)
+			return
+		}
+		if err := w.pool.Reconcile(cfg.Backends); err != nil {
+			log.Printf("config reload: %v (keeping previous config)", err)
+			return
+		}
+		log.Printf("config reload: applied %s", w.path)
+	}
+
+	for {
+		select {
+		case <-w.stop:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case event, ok := <-w.watch.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != filepath.Clean(w.path) {
+				continue
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(debounce, reload)
+		case err, ok := <-w.watch.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("config watcher: %v", err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("writing config file: %v", err)
+	}
+	return path
+}
+
+// Test that LoadConfig parses both YAML and JSON backend config files.
+func TestLoadConfigYAMLAndJSON(t *testing.T) {
+	dir := t.TempDir()
+
+	yamlPath := writeConfigFile(t, dir, "backends.yaml", `
+backends:
+  - id: a
+    url: http://localhost:9001
+    weight: 3
+  - id: b
+    url: http://localhost:9002
+    weight: 1
+`)
+	cfg, err := LoadConfig(yamlPath)
+	if err != nil {
+		t.Fatalf("LoadConfig(yaml): %v", err)
+	}
+	if len(cfg.Backends) != 2 || cfg.Backends[0].ID != "a" || cfg.Backends[0].Weight != 3 {
+		t.Errorf("unexpected yaml config: %+v", cfg.Backends)
+	}
+
+	jsonPath := writeConfigFile(t, dir, "backends.json", `{"backends":[{"id":"a","url":"http://localhost:9001","weight":3}]}`)
+	cfg, err = LoadConfig(jsonPath)
+	if err != nil {
+		t.Fatalf("LoadConfig(json): %v", err)
+	}
+	if len(cfg.Backends) != 1 || cfg.Backends[0].ID != "a" {
+		t.Errorf("unexpected json config: %+v", cfg.Backends)
+	}
+}
+
+// Test that Reconcile reuses an existing *Backend (and its live state) when
+// a config entry's ID and URL are unchanged, and builds a fresh one
+// otherwise.
+func TestServerPoolReconcileReusesUnchangedBackends(t *testing.T) {
+	pool := &ServerPool{}
+	pool.AddBackend(&Backend{ID: "a", URL: mustParseURL(t, "http://localhost:9001")})
+	original, _ := pool.Backend("a")
+	original.IncrementConnections(5)
+
+	err := pool.Reconcile([]BackendConfig{
+		{ID: "a", URL: "http://localhost:9001", Weight: 7},
+		{ID: "b", URL: "http://localhost:9002"},
+	})
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+
+	backends := pool.Backends()
+	if len(backends) != 2 {
+		t.Fatalf("expected 2 backends after reconcile, got %d", len(backends))
+	}
+
+	reused, ok := pool.Backend("a")
+	if !ok {
+		t.Fatal("expected backend \"a\" to survive reconcile")
+	}
+	if reused != original {
+		t.Error("expected reconcile to reuse the existing *Backend for an unchanged entry")
+	}
+	if reused.ConnectionCount() != 5 {
+		t.Errorf("expected reused backend to keep its active connection count, got %d", reused.ConnectionCount())
+	}
+	if reused.Weight != 7 {
+		t.Errorf("expected reused backend's weight to be updated to 7, got %d", reused.Weight)
+	}
+
+	if _, ok := pool.Backend("b"); !ok {
+		t.Error("expected new backend \"b\" to be added by reconcile")
+	}
+}
+
+// Test that Reconcile drops backends no longer present in the config.
+func TestServerPoolReconcileRemovesStaleBackends(t *testing.T) {
+	pool := &ServerPool{}
+	pool.AddBackend(&Backend{ID: "a", URL: mustParseURL(t, "http://localhost:9001")})
+	pool.AddBackend(&Backend{ID: "b", URL: mustParseURL(t, "http://localhost:9002")})
+
+	if err := pool.Reconcile([]BackendConfig{{ID: "a", URL: "http://localhost:9001"}}); err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+
+	if _, ok := pool.Backend("b"); ok {
+		t.Error("expected backend \"b\" to be removed after reconcile")
+	}
+	if len(pool.Backends()) != 1 {
+		t.Errorf("expected 1 backend after reconcile, got %d", len(pool.Backends()))
+	}
+}
+
+// Test that Reconcile registers both new and reused backends with the
+// pool's HealthChecker, so backends added via config hot-reload actually
+// get probed instead of sitting at Alive: true forever.
+func TestServerPoolReconcileConfiguresHealthChecker(t *testing.T) {
+	pool := &ServerPool{}
+	pool.AddBackend(&Backend{ID: "a", URL: mustParseURL(t, "http://localhost:9001"), Alive: true})
+	hc := NewHealthChecker()
+	pool.SetHealthChecker(hc)
+
+	if err := pool.Reconcile([]BackendConfig{
+		{ID: "a", URL: "http://localhost:9001"},
+		{ID: "b", URL: "http://localhost:9002"},
+	}); err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+
+	if len(hc.targets) != 2 {
+		t.Fatalf("expected 2 backends registered with the health checker, got %d", len(hc.targets))
+	}
+}
+
+// Test that Reconcile rejects a config entry naming an unsupported health
+// check type instead of silently falling back to a TCP probe.
+func TestServerPoolReconcileRejectsUnknownHealthCheckType(t *testing.T) {
+	pool := &ServerPool{}
+	hc := NewHealthChecker()
+	pool.SetHealthChecker(hc)
+
+	err := pool.Reconcile([]BackendConfig{
+		{ID: "a", URL: "http://localhost:9001", HealthCheck: &HealthCheckConfig{Type: "grpc"}},
+	})
+	if err == nil {
+		t.Fatal("expected Reconcile to reject an unknown health check type")
+	}
+}
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("parsing url %q: %v", raw, err)
+	}
+	return u
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FastCGI protocol constants (see the spec at
+// https://fastcgi-archives.github.io/FastCGI_Specification.html).
+const (
+	fcgiVersion1 = 1
+
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+
+	fcgiResponder = 1
+
+	maxFCGIRecordContent = 0xffff
+)
+
+// FastCGIConfig configures a FastCGIBackend: where to dial the upstream
+// FastCGI responder (e.g. PHP-FPM) and how to translate inbound HTTP
+// requests into CGI environment variables.
+type FastCGIConfig struct {
+	// Network and Address are passed to net.DialTimeout, e.g. ("tcp",
+	// "127.0.0.1:9000") or ("unix", "/run/php-fpm.sock").
+	Network string
+	Address string
+
+	// Root is the document root used to build SCRIPT_FILENAME.
+	Root string
+	// SplitPath splits the request path into a script path and trailing
+	// PATH_INFO, e.g. []string{".php"} splits "/index.php/extra" into
+	// "/index.php" and "/extra". An empty SplitPath treats the whole
+	// request path as the script with no PATH_INFO.
+	SplitPath []string
+	// Env holds extra CGI environment variables applied after the
+	// request-derived ones, letting config override anything.
+	Env map[string]string
+
+	// DialTimeout bounds connecting to the FastCGI responder.
+	DialTimeout time.Duration
+}
+
+func (c *FastCGIConfig) setDefaults() {
+	if c.Network == "" {
+		c.Network = "tcp"
+	}
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = 5 * time.Second
+	}
+}
+
+// FastCGIBackend is a BackendTransport that speaks the FastCGI protocol
+// directly to a PHP-FPM-style upstream, letting ServerPool front FastCGI
+// applications without a web server such as nginx in between.
+type FastCGIBackend struct {
+	cfg FastCGIConfig
+}
+
+// NewFastCGIBackend returns a FastCGIBackend, applying defaults to any
+// unset fields in cfg.
+func NewFastCGIBackend(cfg FastCGIConfig) *FastCGIBackend {
+	cfg.setDefaults()
+	return &FastCGIBackend{cfg: cfg}
+}
+
+// ServeHTTP dials the configured FastCGI responder, forwards r as a single
+// FastCGI request, and copies the responder's output back to w.
+func (f *FastCGIBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := net.DialTimeout(f.cfg.Network, f.cfg.Address, f.cfg.DialTimeout)
+	if err != nil {
+		http.Error(w, "fastcgi: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer conn.Close()
+
+	resp, err := doFastCGIRequest(conn, r, f.params(r))
+	if err != nil {
+		http.Error(w, "fastcgi: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	dst := w.Header()
+	for k, v := range resp.Header {
+		dst[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// params builds the CGI environment for r: the standard variables
+// (SCRIPT_FILENAME, PATH_INFO, QUERY_STRING, ...), every inbound header as
+// HTTP_*, and finally f.cfg.Env layered on top so operators can override
+// anything.
+func (f *FastCGIBackend) params(r *http.Request) map[string]string {
+	scriptName, pathInfo := f.splitScript(r.URL.Path)
+
+	contentLength := ""
+	if r.ContentLength >= 0 {
+		contentLength = strconv.FormatInt(r.ContentLength, 10)
+	}
+
+	p := map[string]string{
+		"REQUEST_METHOD":    r.Method,
+		"SERVER_PROTOCOL":   r.Proto,
+		"QUERY_STRING":      r.URL.RawQuery,
+		"SCRIPT_NAME":       scriptName,
+		"SCRIPT_FILENAME":   path.Join(f.cfg.Root, scriptName),
+		"PATH_INFO":         pathInfo,
+		"DOCUMENT_ROOT":     f.cfg.Root,
+		"REQUEST_URI":       r.URL.RequestURI(),
+		"SERVER_SOFTWARE":   "go-load-balancer",
+		"REMOTE_ADDR":       clientIP(r),
+		"CONTENT_TYPE":      r.Header.Get("Content-Type"),
+		"CONTENT_LENGTH":    contentLength,
+		"GATEWAY_INTERFACE": "CGI/1.1",
+	}
+	if r.TLS != nil {
+		p["HTTPS"] = "on"
+	}
+	for name, values := range r.Header {
+		key := "HTTP_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		p[key] = strings.Join(values, ", ")
+	}
+	for k, v := range f.cfg.Env {
+		p[k] = v
+	}
+	return p
+}
+
+func (f *FastCGIBackend) splitScript(p string) (script, pathInfo string) {
+	for _, sep := range f.cfg.SplitPath {
+		if idx := strings.Index(p, sep); idx != -1 {
+			cut := idx + len(sep)
+			return p[:cut], p[cut:]
+		}
+	}
+	return p, ""
+}
+
+// doFastCGIRequest drives a single FastCGI request over conn: BeginRequest,
+// Params, Stdin (streaming r.Body), then reassembles the Stdout/Stderr
+// records into an http.Response once EndRequest arrives.
+func doFastCGIRequest(conn net.Conn, r *http.Request, params map[string]string) (*http.Response, error) {
+	const reqID = 1
+
+	if err := writeBeginRequest(conn, reqID, fcgiResponder); err != nil {
+		return nil, fmt.Errorf("begin request: %w", err)
+	}
+	if err := writeRecordStream(conn, fcgiParams, reqID, encodeParams(params)); err != nil {
+		return nil, fmt.Errorf("params: %w", err)
+	}
+
+	if r.Body != nil {
+		buf := make([]byte, 32*1024)
+		for {
+			n, rerr := r.Body.Read(buf)
+			if n > 0 {
+				if err := writeRecord(conn, fcgiStdin, reqID, buf[:n]); err != nil {
+					return nil, fmt.Errorf("stdin: %w", err)
+				}
+			}
+			if rerr == io.EOF {
+				break
+			}
+			if rerr != nil {
+				return nil, fmt.Errorf("reading request body: %w", rerr)
+			}
+		}
+	}
+	if err := writeRecord(conn, fcgiStdin, reqID, nil); err != nil {
+		return nil, fmt.Errorf("stdin: %w", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	for {
+		hdr, content, err := readRecord(conn)
+		if err != nil {
+			return nil, fmt.Errorf("reading response: %w", err)
+		}
+		switch hdr.recType {
+		case fcgiStdout:
+			stdout.Write(content)
+		case fcgiStderr:
+			stderr.Write(content)
+		case fcgiEndRequest:
+			return parseCGIResponse(stdout.Bytes())
+		}
+	}
+}
+
+type fcgiHeader struct {
+	recType       uint8
+	requestID     uint16
+	contentLength uint16
+	paddingLength uint8
+}
+
+// writeRecord writes a single FastCGI record, splitting content across
+// multiple records if it exceeds the protocol's 16-bit length field.
+func writeRecord(w io.Writer, recType uint8, reqID uint16, content []byte) error {
+	for {
+		chunk := content
+		if len(chunk) > maxFCGIRecordContent {
+			chunk = chunk[:maxFCGIRecordContent]
+		}
+		if err := writeRecordChunk(w, recType, reqID, chunk); err != nil {
+			return err
+		}
+		content = content[len(chunk):]
+		if len(content) == 0 {
+			return nil
+		}
+	}
+}
+
+// writeRecordStream is like writeRecord but always terminates the stream
+// with an empty record, as FCGI_PARAMS and FCGI_STDIN require.
+func writeRecordStream(w io.Writer, recType uint8, reqID uint16, content []byte) error {
+	if len(content) > 0 {
+		if err := writeRecord(w, recType, reqID, content); err != nil {
+			return err
+		}
+	}
+	return writeRecordChunk(w, recType, reqID, nil)
+}
+
+func writeRecordChunk(w io.Writer, recType uint8, reqID uint16, content []byte) error {
+	padding := (8 - len(content)%8) % 8
+	header := [8]byte{
+		fcgiVersion1,
+		recType,
+		byte(reqID >> 8), byte(reqID),
+		byte(len(content) >> 8), byte(len(content)),
+		byte(padding),
+		0,
+	}
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if len(content) > 0 {
+		if _, err := w.Write(content); err != nil {
+			return err
+		}
+	}
+	if padding > 0 {
+		if _, err := w.Write(make([]byte, padding)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeBeginRequest(w io.Writer, reqID uint16, role uint16) error {
+	body := [8]byte{byte(role >> 8), byte(role)}
+	return writeRecordChunk(w, fcgiBeginRequest, reqID, body[:])
+}
+
+func readRecord(r io.Reader) (fcgiHeader, []byte, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return fcgiHeader{}, nil, err
+	}
+	hdr := fcgiHeader{
+		recType:       buf[1],
+		requestID:     binary.BigEndian.Uint16(buf[2:4]),
+		contentLength: binary.BigEndian.Uint16(buf[4:6]),
+		paddingLength: buf[6],
+	}
+
+	content := make([]byte, hdr.contentLength)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return hdr, nil, err
+	}
+	if hdr.paddingLength > 0 {
+		if _, err := io.CopyN(io.Discard, r, int64(hdr.paddingLength)); err != nil {
+			return hdr, nil, err
+		}
+	}
+	return hdr, content, nil
+}
+
+// encodeParams serializes params as FastCGI name-value pairs. Keys are
+// sorted for deterministic output (handy for tests); the protocol doesn't
+// care about order.
+func encodeParams(params map[string]string) []byte {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		v := params[k]
+		writeParamLength(&buf, len(k))
+		writeParamLength(&buf, len(v))
+		buf.WriteString(k)
+		buf.WriteString(v)
+	}
+	return buf.Bytes()
+}
+
+// writeParamLength encodes a name/value length per the FastCGI spec: one
+// byte when it fits in 7 bits, four bytes with the high bit set otherwise.
+func writeParamLength(buf *bytes.Buffer, n int) {
+	if n < 128 {
+		buf.WriteByte(byte(n))
+		return
+	}
+	buf.WriteByte(byte(n>>24) | 0x80)
+	buf.WriteByte(byte(n >> 16))
+	buf.WriteByte(byte(n >> 8))
+	buf.WriteByte(byte(n))
+}
+
+// parseCGIResponse parses a CGI-style response (headers, blank line, body)
+// out of the FastCGI responder's aggregated stdout into an http.Response.
+// A "Status" header, if present, sets the HTTP status code; it otherwise
+// defaults to 200.
+func parseCGIResponse(data []byte) (*http.Response, error) {
+	tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	mimeHeader, err := tp.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("parsing cgi response headers: %w", err)
+	}
+	header := http.Header(mimeHeader)
+
+	status := http.StatusOK
+	if s := header.Get("Status"); s != "" {
+		if fields := strings.Fields(s); len(fields) > 0 {
+			if code, convErr := strconv.Atoi(fields[0]); convErr == nil {
+				status = code
+			}
+		}
+		header.Del("Status")
+	}
+
+	body, _ := io.ReadAll(tp.R)
+	return &http.Response{
+		StatusCode: status,
+		Header:     header,
+		Body:       io.NopCloser(bytes.NewReader(body)),
+	}, nil
+}
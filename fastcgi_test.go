@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeFastCGIResponder is a minimal FastCGI responder used to exercise
+// FastCGIBackend end-to-end without a real PHP-FPM: it reads exactly one
+// request (BeginRequest, Params, Stdin) off the connection and replies with
+// a fixed CGI response built from the decoded params.
+type fakeFastCGIResponder struct {
+	ln       net.Listener
+	params   map[string]string
+	stdin    []byte
+	response []byte
+}
+
+func newFakeFastCGIResponder(t *testing.T, response []byte) *fakeFastCGIResponder {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	f := &fakeFastCGIResponder{ln: ln, response: response}
+	go f.serveOne(t)
+	return f
+}
+
+func (f *fakeFastCGIResponder) addr() string { return f.ln.Addr().String() }
+
+func (f *fakeFastCGIResponder) serveOne(t *testing.T) {
+	conn, err := f.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	defer f.ln.Close()
+
+	var paramBytes, stdin bytes.Buffer
+	for {
+		hdr, content, err := readRecord(conn)
+		if err != nil {
+			return
+		}
+		switch hdr.recType {
+		case fcgiBeginRequest:
+			// nothing to validate beyond "it arrived"
+		case fcgiParams:
+			if len(content) == 0 {
+				f.params = decodeParams(t, paramBytes.Bytes())
+			} else {
+				paramBytes.Write(content)
+			}
+		case fcgiStdin:
+			if len(content) == 0 {
+				f.stdin = stdin.Bytes()
+				writeRecord(conn, fcgiStdout, hdr.requestID, f.response)
+				writeEndRequest(conn, hdr.requestID)
+				return
+			}
+			stdin.Write(content)
+		}
+	}
+}
+
+func writeEndRequest(w io.Writer, reqID uint16) {
+	writeRecordChunk(w, fcgiEndRequest, reqID, make([]byte, 8))
+}
+
+func decodeParams(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	params := map[string]string{}
+	for len(data) > 0 {
+		nameLen, n1 := readParamLength(data)
+		data = data[n1:]
+		valueLen, n2 := readParamLength(data)
+		data = data[n2:]
+		name := string(data[:nameLen])
+		data = data[nameLen:]
+		value := string(data[:valueLen])
+		data = data[valueLen:]
+		params[name] = value
+	}
+	return params
+}
+
+func readParamLength(data []byte) (int, int) {
+	if data[0]&0x80 == 0 {
+		return int(data[0]), 1
+	}
+	return int(binary.BigEndian.Uint32(data) &^ (1 << 31)), 4
+}
+
+// Test that FastCGIBackend translates an HTTP request into the expected
+// CGI parameters and streams the body as Stdin.
+func TestFastCGIBackendBuildsParams(t *testing.T) {
+	responder := newFakeFastCGIResponder(t, []byte("Content-Type: text/plain\r\n\r\nhi"))
+
+	backend := NewFastCGIBackend(FastCGIConfig{
+		Network:   "tcp",
+		Address:   responder.addr(),
+		Root:      "/var/www",
+		SplitPath: []string{".php"},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/index.php/extra?x=1", strings.NewReader("body-data"))
+	req.Header.Set("X-Test", "yes")
+	w := httptest.NewRecorder()
+
+	backend.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Body.String() != "hi" {
+		t.Errorf("expected body %q, got %q", "hi", w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain" {
+		t.Errorf("expected Content-Type text/plain, got %q", ct)
+	}
+
+	if string(responder.stdin) != "body-data" {
+		t.Errorf("expected stdin %q, got %q", "body-data", responder.stdin)
+	}
+	if responder.params["SCRIPT_FILENAME"] != "/var/www/index.php" {
+		t.Errorf("expected SCRIPT_FILENAME /var/www/index.php, got %q", responder.params["SCRIPT_FILENAME"])
+	}
+	if responder.params["PATH_INFO"] != "/extra" {
+		t.Errorf("expected PATH_INFO /extra, got %q", responder.params["PATH_INFO"])
+	}
+	if responder.params["QUERY_STRING"] != "x=1" {
+		t.Errorf("expected QUERY_STRING x=1, got %q", responder.params["QUERY_STRING"])
+	}
+	if responder.params["HTTP_X_TEST"] != "yes" {
+		t.Errorf("expected HTTP_X_TEST yes, got %q", responder.params["HTTP_X_TEST"])
+	}
+}
+
+// Test that a request with an unknown Content-Length (as Go reports for
+// chunked-encoding requests, via ContentLength == -1) sends an empty
+// CONTENT_LENGTH rather than the literal string "-1", which FastCGI
+// responders like PHP-FPM don't accept.
+func TestFastCGIBackendUnknownContentLength(t *testing.T) {
+	responder := newFakeFastCGIResponder(t, []byte("Content-Type: text/plain\r\n\r\nhi"))
+
+	backend := NewFastCGIBackend(FastCGIConfig{
+		Network: "tcp",
+		Address: responder.addr(),
+		Root:    "/var/www",
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/index.php", strings.NewReader("body-data"))
+	req.ContentLength = -1
+	w := httptest.NewRecorder()
+
+	backend.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if cl, ok := responder.params["CONTENT_LENGTH"]; !ok || cl != "" {
+		t.Errorf("expected empty CONTENT_LENGTH for an unknown length, got %q", cl)
+	}
+}
+
+// Test that a "Status" header in the CGI response sets the HTTP status
+// code and is not leaked through as a response header.
+func TestFastCGIBackendStatusHeader(t *testing.T) {
+	responder := newFakeFastCGIResponder(t, []byte("Status: 404 Not Found\r\nContent-Type: text/plain\r\n\r\nmissing"))
+
+	backend := NewFastCGIBackend(FastCGIConfig{Network: "tcp", Address: responder.addr()})
+
+	req := httptest.NewRequest(http.MethodGet, "/missing.php", nil)
+	w := httptest.NewRecorder()
+	backend.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+	if w.Header().Get("Status") != "" {
+		t.Error("expected Status header to be stripped from the response")
+	}
+	if w.Body.String() != "missing" {
+		t.Errorf("expected body %q, got %q", "missing", w.Body.String())
+	}
+}
+
+// Test that buildBackend wires up a FastCGI transport for type: fastcgi,
+// deriving the dial target from the backend URL.
+func TestBuildBackendFastCGI(t *testing.T) {
+	cfg := BackendConfig{
+		ID:      "php",
+		URL:     "tcp://127.0.0.1:" + strconv.Itoa(9000),
+		Type:    "fastcgi",
+		FastCGI: &FastCGIConfigFile{Root: "/srv/app"},
+	}
+
+	b, err := cfg.buildBackend()
+	if err != nil {
+		t.Fatalf("buildBackend: %v", err)
+	}
+	if b.Transport == nil {
+		t.Fatal("expected a Transport to be set for a fastcgi backend")
+	}
+	if _, ok := b.Transport.(*FastCGIBackend); !ok {
+		t.Errorf("expected *FastCGIBackend, got %T", b.Transport)
+	}
+	if b.ReverseProxy != nil {
+		t.Error("expected ReverseProxy to be unset for a fastcgi backend")
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ProbeType selects how a HealthChecker verifies that a backend is reachable.
+//
+// A third type, gRPC health checking (grpc.health.v1.Health/Check), is
+// intentionally not implemented yet: an earlier attempt only dialed and
+// closed a TCP connection without speaking the protocol, which would have
+// reported a backend healthy even if its gRPC health service reported
+// NOT_SERVING. Add it for real once the project takes a grpc dependency.
+type ProbeType int
+
+const (
+	// ProbeTCP dials the backend's address and considers a successful
+	// connection a pass.
+	ProbeTCP ProbeType = iota
+	// ProbeHTTP issues a GET against Path and checks the response status
+	// falls within [StatusMin, StatusMax].
+	ProbeHTTP
+)
+
+// ProbeConfig describes how and how often a single backend is actively
+// probed, and how many consecutive results are required before its Alive
+// flag flips.
+type ProbeConfig struct {
+	Type ProbeType
+
+	// Interval between probes and Timeout for a single probe attempt.
+	Interval time.Duration
+	Timeout  time.Duration
+
+	// Path is the HTTP path probed when Type is ProbeHTTP, e.g. "/healthz".
+	Path string
+	// StatusMin and StatusMax bound the accepted HTTP status range when
+	// Type is ProbeHTTP. Both default to treating 200-399 as healthy.
+	StatusMin int
+	StatusMax int
+
+	// HealthyThreshold is the number of consecutive successes required to
+	// mark a down backend alive again.
+	HealthyThreshold int
+	// UnhealthyThreshold is the number of consecutive failures required to
+	// mark an up backend down.
+	UnhealthyThreshold int
+}
+
+func (c *ProbeConfig) setDefaults() {
+	if c.Interval <= 0 {
+		c.Interval = 10 * time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 2 * time.Second
+	}
+	if c.Path == "" {
+		c.Path = "/healthz"
+	}
+	if c.StatusMin == 0 {
+		c.StatusMin = 200
+	}
+	if c.StatusMax == 0 {
+		c.StatusMax = 399
+	}
+	if c.HealthyThreshold <= 0 {
+		c.HealthyThreshold = 2
+	}
+	if c.UnhealthyThreshold <= 0 {
+		c.UnhealthyThreshold = 3
+	}
+}
+
+// probeTarget pairs a backend with the probe config and run state used to
+// check it.
+type probeTarget struct {
+	backend *Backend
+	cfg     ProbeConfig
+	client  *http.Client
+
+	mux       sync.Mutex
+	successes int
+	failures  int
+}
+
+// HealthChecker actively probes a set of backends on their own intervals
+// and flips their Alive flag once enough consecutive results land on one
+// side of the configured thresholds. It complements the passive
+// CircuitBreaker rather than replacing it.
+type HealthChecker struct {
+	mux     sync.Mutex
+	targets []*probeTarget
+	started bool
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewHealthChecker returns an empty HealthChecker; call Configure for each
+// backend, then Start. Configure may also be called after Start (e.g. for
+// a backend added via config hot-reload or the admin API), in which case
+// its probe loop launches immediately instead of waiting for Start.
+func NewHealthChecker() *HealthChecker {
+	return &HealthChecker{stop: make(chan struct{})}
+}
+
+// Configure registers b to be probed per cfg, applying defaults to any
+// unset fields. Calling Configure again for the same backend replaces its
+// config. If the HealthChecker has already been started, a new target's
+// probe loop is launched right away.
+func (hc *HealthChecker) Configure(b *Backend, cfg ProbeConfig) {
+	cfg.setDefaults()
+	hc.mux.Lock()
+	defer hc.mux.Unlock()
+	for _, t := range hc.targets {
+		if t.backend == b {
+			t.cfg = cfg
+			return
+		}
+	}
+	target := &probeTarget{
+		backend: b,
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+	}
+	hc.targets = append(hc.targets, target)
+	if hc.started {
+		hc.wg.Add(1)
+		go hc.run(target)
+	}
+}
+
+// Start launches one probe loop per configured backend. It is safe to call
+// Start only once per HealthChecker.
+func (hc *HealthChecker) Start() {
+	hc.mux.Lock()
+	hc.started = true
+	targets := make([]*probeTarget, len(hc.targets))
+	copy(targets, hc.targets)
+	hc.mux.Unlock()
+
+	for _, t := range targets {
+		hc.wg.Add(1)
+		go hc.run(t)
+	}
+}
+
+// Stop signals every probe loop to exit and waits for them to finish.
+func (hc *HealthChecker) Stop() {
+	close(hc.stop)
+	hc.wg.Wait()
+}
+
+func (hc *HealthChecker) run(t *probeTarget) {
+	defer hc.wg.Done()
+	ticker := time.NewTicker(t.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hc.stop:
+			return
+		case <-ticker.C:
+			hc.probeOnce(t)
+		}
+	}
+}
+
+func (hc *HealthChecker) probeOnce(t *probeTarget) {
+	err := probe(t.cfg, t.client, t.backend.URL.Host)
+
+	t.backend.Stats.mux.Lock()
+	t.backend.Stats.LastCheck = time.Now()
+	t.backend.Stats.mux.Unlock()
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if err == nil {
+		t.successes++
+		t.failures = 0
+		if !t.backend.IsAlive() && t.successes >= t.cfg.HealthyThreshold {
+			t.backend.SetAlive(true)
+		}
+		return
+	}
+
+	metrics.IncHealthCheckFailure(t.backend.ID)
+
+	t.failures++
+	t.successes = 0
+	if t.backend.IsAlive() && t.failures >= t.cfg.UnhealthyThreshold {
+		t.backend.SetAlive(false)
+	}
+}
+
+// probe runs a single health check of addr per cfg and returns a non-nil
+// error describing why the backend is considered unhealthy.
+func probe(cfg ProbeConfig, client *http.Client, addr string) error {
+	switch cfg.Type {
+	case ProbeHTTP:
+		return probeHTTP(cfg, client, addr)
+	default:
+		return probeTCP(cfg, addr)
+	}
+}
+
+func probeTCP(cfg ProbeConfig, addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func probeHTTP(cfg ProbeConfig, client *http.Client, addr string) error {
+	url := fmt.Sprintf("http://%s%s", addr, cfg.Path)
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < cfg.StatusMin || resp.StatusCode > cfg.StatusMax {
+		return fmt.Errorf("health probe: unexpected status %d from %s", resp.StatusCode, url)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newHealthTestBackend(t *testing.T, rawUrl string) *Backend {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		t.Fatalf("failed to parse url: %v", err)
+	}
+	return &Backend{URL: u, Alive: true}
+}
+
+// Test that a backend is flipped down only after UnhealthyThreshold
+// consecutive failed probes, not on the first blip.
+func TestHealthCheckerUnhealthyThreshold(t *testing.T) {
+	backend := newHealthTestBackend(t, "http://127.0.0.1:1")
+
+	hc := NewHealthChecker()
+	hc.Configure(backend, ProbeConfig{Type: ProbeTCP, UnhealthyThreshold: 3})
+	target := hc.targets[0]
+
+	hc.probeOnce(target)
+	if !backend.IsAlive() {
+		t.Fatal("expected backend to stay alive after a single failed probe")
+	}
+	hc.probeOnce(target)
+	if !backend.IsAlive() {
+		t.Fatal("expected backend to stay alive after two failed probes")
+	}
+	hc.probeOnce(target)
+	if backend.IsAlive() {
+		t.Error("expected backend to be marked down after three consecutive failures")
+	}
+}
+
+// Test that a down backend is only reinstated after HealthyThreshold
+// consecutive successful probes.
+func TestHealthCheckerHealthyThreshold(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	backend := newHealthTestBackend(t, server.URL)
+	backend.SetAlive(false)
+
+	hc := NewHealthChecker()
+	hc.Configure(backend, ProbeConfig{Type: ProbeHTTP, HealthyThreshold: 2})
+	target := hc.targets[0]
+
+	hc.probeOnce(target)
+	if backend.IsAlive() {
+		t.Fatal("expected backend to stay down after a single successful probe")
+	}
+	hc.probeOnce(target)
+	if !backend.IsAlive() {
+		t.Error("expected backend to be reinstated after two consecutive successes")
+	}
+}
+
+// Test that Configure launches a probe loop immediately for a backend
+// added after Start, rather than only probing backends present at
+// construction time.
+func TestHealthCheckerConfigureAfterStart(t *testing.T) {
+	var probes int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	hc := NewHealthChecker()
+	hc.Start()
+	defer hc.Stop()
+
+	backend := newHealthTestBackend(t, server.URL)
+	hc.Configure(backend, ProbeConfig{Type: ProbeHTTP, Interval: 10 * time.Millisecond})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&probes) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&probes) == 0 {
+		t.Fatal("expected a backend configured after Start to be probed")
+	}
+}
+
+// Test that a single passive failure does not open the circuit, but enough
+// failures within the window does.
+func TestCircuitBreakerOpensOnThreshold(t *testing.T) {
+	backend := newHealthTestBackend(t, "http://localhost:5001")
+	cb := NewCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 3, Window: time.Minute, Cooldown: time.Minute})
+
+	cb.RecordFailure(backend)
+	if !cb.Allow(backend) {
+		t.Fatal("expected circuit to stay closed after one failure")
+	}
+	cb.RecordFailure(backend)
+	cb.RecordFailure(backend)
+	if cb.Allow(backend) {
+		t.Error("expected circuit to open after reaching the failure threshold")
+	}
+}
+
+// Test that the circuit closes again once a request succeeds.
+func TestCircuitBreakerClosesOnSuccess(t *testing.T) {
+	backend := newHealthTestBackend(t, "http://localhost:5002")
+	cb := NewCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1, Window: time.Minute, Cooldown: time.Millisecond})
+
+	cb.RecordFailure(backend)
+	if cb.Allow(backend) {
+		t.Fatal("expected circuit to be open after reaching the failure threshold")
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if !cb.Allow(backend) {
+		t.Fatal("expected cooldown to have elapsed, allowing a half-open probe")
+	}
+	if backend.Stats.State() != CircuitHalfOpen {
+		t.Fatalf("expected circuit to report half-open once the probe is let through, got %s", backend.Stats.State())
+	}
+
+	cb.RecordSuccess(backend)
+	if backend.Stats.State() != CircuitClosed {
+		t.Errorf("expected circuit to be closed after a success, got %s", backend.Stats.State())
+	}
+}
+
+// Test that while a half-open probe is in flight, Allow blocks any other
+// concurrent request, and that the probe failing sends the circuit
+// straight back to open.
+func TestCircuitBreakerHalfOpenBlocksConcurrentProbesAndReopensOnFailure(t *testing.T) {
+	backend := newHealthTestBackend(t, "http://localhost:5005")
+	cb := NewCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1, Window: time.Minute, Cooldown: time.Millisecond})
+
+	cb.RecordFailure(backend)
+	time.Sleep(2 * time.Millisecond)
+
+	if !cb.Allow(backend) {
+		t.Fatal("expected the first request after cooldown to be let through as the half-open probe")
+	}
+	if cb.Allow(backend) {
+		t.Error("expected a second concurrent request to be blocked while the half-open probe is in flight")
+	}
+
+	cb.RecordFailure(backend)
+	if backend.Stats.State() != CircuitOpen {
+		t.Errorf("expected a failed half-open probe to reopen the circuit, got %s", backend.Stats.State())
+	}
+	if cb.Allow(backend) {
+		t.Error("expected circuit to stay closed to traffic immediately after reopening")
+	}
+}
+
+// Test that GetNextPeerForRequest skips a backend whose circuit is open.
+func TestServerPoolSkipsOpenCircuit(t *testing.T) {
+	serverPool := &ServerPool{}
+	healthy := newHealthTestBackend(t, "http://localhost:5003")
+	tripped := newHealthTestBackend(t, "http://localhost:5004")
+	serverPool.AddBackend(healthy)
+	serverPool.AddBackend(tripped)
+
+	cb := NewCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1, Window: time.Minute, Cooldown: time.Minute})
+	cb.RecordFailure(tripped)
+	serverPool.SetCircuitBreaker(cb)
+
+	for i := 0; i < 10; i++ {
+		peer := serverPool.GetNextPeerForRequest(nil)
+		if peer != healthy {
+			t.Fatalf("expected only the healthy backend to be picked, got %v", peer)
+		}
+	}
+}
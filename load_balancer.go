@@ -0,0 +1,645 @@
+// Command load-balancer is a small HTTP reverse-proxy load balancer.
+//
+// It fans incoming requests out across a pool of backends, skipping any
+// backend that has been marked down, and retries a failed request against a
+// different backend before giving up.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BackendTransport is satisfied by anything that can serve a proxied
+// request against a backend — *httputil.ReverseProxy for plain HTTP
+// backends, or a FastCGIBackend for PHP-FPM-style upstreams. Setting a
+// Backend's Transport lets it speak a different wire protocol without the
+// request path needing to know which one it is.
+type BackendTransport interface {
+	ServeHTTP(w http.ResponseWriter, r *http.Request)
+}
+
+// Backend represents a single upstream server in the pool.
+type Backend struct {
+	ID           string
+	URL          *url.URL
+	Alive        bool
+	Weight       int
+	MaxConns     int64
+	ActiveConns  int64
+	ReverseProxy *httputil.ReverseProxy
+	Transport    BackendTransport
+	Stats        BackendStats
+
+	mux           sync.RWMutex
+	currentWeight int
+	draining      int32
+}
+
+// serveHTTP proxies r to the backend, preferring the pluggable Transport
+// and falling back to the legacy ReverseProxy field for plain HTTP
+// backends that only set that one.
+func (b *Backend) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if b.Transport != nil {
+		b.Transport.ServeHTTP(w, r)
+		return
+	}
+	b.ReverseProxy.ServeHTTP(w, r)
+}
+
+// SetAlive updates the backend's health status.
+func (b *Backend) SetAlive(alive bool) {
+	b.mux.Lock()
+	b.Alive = alive
+	b.mux.Unlock()
+}
+
+// IsAlive reports whether the backend is currently considered healthy.
+func (b *Backend) IsAlive() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.Alive
+}
+
+// SetDraining toggles the backend's drain mode. A draining backend is never
+// picked for new requests but keeps serving the ones it already has.
+func (b *Backend) SetDraining(draining bool) {
+	var v int32
+	if draining {
+		v = 1
+	}
+	atomic.StoreInt32(&b.draining, v)
+}
+
+// IsDraining reports whether the backend is being drained ahead of removal.
+func (b *Backend) IsDraining() bool {
+	return atomic.LoadInt32(&b.draining) != 0
+}
+
+// IncrementConnections adjusts the backend's active connection counter and
+// returns the resulting value. Pass a negative delta to decrement.
+func (b *Backend) IncrementConnections(delta int64) int64 {
+	return atomic.AddInt64(&b.ActiveConns, delta)
+}
+
+// ConnectionCount returns the backend's current active connection count.
+func (b *Backend) ConnectionCount() int64 {
+	return atomic.LoadInt64(&b.ActiveConns)
+}
+
+// addCurrentWeight adjusts the smooth-weighted-round-robin bookkeeping field
+// and returns the resulting value.
+func (b *Backend) addCurrentWeight(delta int) int {
+	b.mux.Lock()
+	b.currentWeight += delta
+	v := b.currentWeight
+	b.mux.Unlock()
+	return v
+}
+
+// ServerPool holds the set of backends a balancer chooses from. The backend
+// slice itself is stored in an atomic.Value and always replaced wholesale
+// (copy-on-write) so readers on the hot request path never block on writers
+// reconfiguring the pool, and in-flight requests keep the *Backend they
+// already picked even if it's removed mid-request.
+type ServerPool struct {
+	backends atomic.Value // []*Backend
+
+	writeMux sync.Mutex // serializes AddBackend/RemoveBackend/Reconcile
+
+	mux           sync.RWMutex
+	current       uint64
+	policy        BalancingPolicy
+	breaker       *CircuitBreaker
+	retryPolicy   *RetryPolicy
+	healthChecker *HealthChecker
+}
+
+// loadBackends returns the pool's current backend slice. Safe to call
+// before any backend has been added.
+func (s *ServerPool) loadBackends() []*Backend {
+	v := s.backends.Load()
+	if v == nil {
+		return nil
+	}
+	return v.([]*Backend)
+}
+
+// AddBackend registers a backend with the pool.
+func (s *ServerPool) AddBackend(b *Backend) {
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+	cur := s.loadBackends()
+	next := make([]*Backend, len(cur), len(cur)+1)
+	copy(next, cur)
+	next = append(next, b)
+	s.backends.Store(next)
+}
+
+// RemoveBackend drops the backend with the given ID from the pool. It
+// reports whether a matching backend was found.
+func (s *ServerPool) RemoveBackend(id string) bool {
+	s.writeMux.Lock()
+	defer s.writeMux.Unlock()
+	cur := s.loadBackends()
+	next := make([]*Backend, 0, len(cur))
+	found := false
+	for _, b := range cur {
+		if b.ID == id {
+			found = true
+			continue
+		}
+		next = append(next, b)
+	}
+	if !found {
+		return false
+	}
+	s.backends.Store(next)
+	return true
+}
+
+// Reconcile atomically replaces the pool's backends with the set described
+// by configs. Backends whose ID and URL are unchanged are reused in place
+// (preserving their Alive state, stats, and in-flight connection count);
+// everything else is rebuilt from scratch. It's the hot-reload path used by
+// ConfigWatcher and the admin API.
+func (s *ServerPool) Reconcile(configs []BackendConfig) error {
+	existing := map[string]*Backend{}
+	for _, b := range s.loadBackends() {
+		existing[b.ID] = b
+	}
+
+	hc := s.HealthChecker()
+
+	next := make([]*Backend, 0, len(configs))
+	for _, c := range configs {
+		id := c.id()
+		if b, ok := existing[id]; ok && b.URL.String() == c.URL {
+			b.Weight = c.Weight
+			b.MaxConns = c.MaxConns
+			next = append(next, b)
+			if hc != nil {
+				probeCfg, err := c.HealthCheck.probeConfig()
+				if err != nil {
+					return fmt.Errorf("backend %q: %w", id, err)
+				}
+				hc.Configure(b, probeCfg)
+			}
+			continue
+		}
+		b, err := c.buildBackend()
+		if err != nil {
+			return fmt.Errorf("backend %q: %w", id, err)
+		}
+		next = append(next, b)
+		if hc != nil {
+			probeCfg, err := c.HealthCheck.probeConfig()
+			if err != nil {
+				return fmt.Errorf("backend %q: %w", id, err)
+			}
+			hc.Configure(b, probeCfg)
+		}
+	}
+
+	s.writeMux.Lock()
+	s.backends.Store(next)
+	s.writeMux.Unlock()
+	return nil
+}
+
+// Backend returns the backend registered under id, if any.
+func (s *ServerPool) Backend(id string) (*Backend, bool) {
+	for _, b := range s.loadBackends() {
+		if b.ID == id {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+// Drain marks the backend identified by id as draining (so it stops
+// receiving new requests) and blocks until its active connection count
+// reaches zero or timeout elapses. It reports whether the drain completed
+// cleanly before timeout.
+func (s *ServerPool) Drain(id string, timeout time.Duration) bool {
+	b, ok := s.Backend(id)
+	if !ok {
+		return false
+	}
+	b.SetDraining(true)
+
+	deadline := time.Now().Add(timeout)
+	for b.ConnectionCount() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+	return true
+}
+
+// SetPolicy selects the BalancingPolicy used by GetNextPeer. Passing nil
+// restores the default round-robin behavior.
+func (s *ServerPool) SetPolicy(p BalancingPolicy) {
+	s.mux.Lock()
+	s.policy = p
+	s.mux.Unlock()
+}
+
+// Policy returns the pool's configured BalancingPolicy, or nil if it's using
+// the default round-robin behavior.
+func (s *ServerPool) Policy() BalancingPolicy {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.policy
+}
+
+// SetCircuitBreaker enables passive failure tracking for the pool. Passing
+// nil disables the circuit breaker entirely.
+func (s *ServerPool) SetCircuitBreaker(cb *CircuitBreaker) {
+	s.mux.Lock()
+	s.breaker = cb
+	s.mux.Unlock()
+}
+
+// CircuitBreaker returns the pool's configured circuit breaker, or nil.
+func (s *ServerPool) CircuitBreaker() *CircuitBreaker {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.breaker
+}
+
+// SetRetryPolicy configures how lb retries failed requests against this
+// pool. Passing nil restores the default (DefaultMaxRetries, 5xx-only, no
+// hedging, no budget).
+func (s *ServerPool) SetRetryPolicy(p *RetryPolicy) {
+	s.mux.Lock()
+	s.retryPolicy = p
+	s.mux.Unlock()
+}
+
+// RetryPolicy returns the pool's configured retry policy, or nil.
+func (s *ServerPool) RetryPolicy() *RetryPolicy {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.retryPolicy
+}
+
+// SetHealthChecker wires the pool up to a HealthChecker so that backends
+// added later, via config hot-reload (Reconcile) or the admin API, get
+// actively probed too instead of only the ones present at startup. Passing
+// nil disables that wiring.
+func (s *ServerPool) SetHealthChecker(hc *HealthChecker) {
+	s.mux.Lock()
+	s.healthChecker = hc
+	s.mux.Unlock()
+}
+
+// HealthChecker returns the pool's configured health checker, or nil.
+func (s *ServerPool) HealthChecker() *HealthChecker {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.healthChecker
+}
+
+// Backends returns a snapshot of the pool's backends. The slice itself is
+// never mutated in place, so callers may range over it freely.
+func (s *ServerPool) Backends() []*Backend {
+	return s.loadBackends()
+}
+
+// NextIndex advances and returns the pool's round-robin cursor, wrapping
+// around the current number of backends.
+func (s *ServerPool) NextIndex() int {
+	n := len(s.loadBackends())
+	if n == 0 {
+		return 0
+	}
+	return int(atomic.AddUint64(&s.current, 1) % uint64(n))
+}
+
+// MarkBackendStatus flips the Alive flag of the backend matching url, if any.
+func (s *ServerPool) MarkBackendStatus(u *url.URL, alive bool) {
+	for _, b := range s.loadBackends() {
+		if b.URL.String() == u.String() {
+			b.SetAlive(alive)
+			return
+		}
+	}
+}
+
+// GetNextPeer returns the next backend to use for a request, per the pool's
+// configured BalancingPolicy (round-robin by default).
+func (s *ServerPool) GetNextPeer() *Backend {
+	return s.GetNextPeerForRequest(nil)
+}
+
+// GetNextPeerForRequest is like GetNextPeer but lets the policy take the
+// inbound request into account (e.g. for consistent hashing).
+func (s *ServerPool) GetNextPeerForRequest(r *http.Request) *Backend {
+	excluded := excludedBackendsFromContext(r)
+
+	all := s.loadBackends()
+	backends := make([]*Backend, 0, len(all))
+	for _, b := range all {
+		if b.IsAlive() && !b.IsDraining() && !excluded[b.ID] {
+			backends = append(backends, b)
+		}
+	}
+	s.mux.RLock()
+	policy := s.policy
+	breaker := s.breaker
+	s.mux.RUnlock()
+
+	if breaker != nil {
+		open := backends[:0]
+		for _, b := range backends {
+			if breaker.Allow(b) {
+				open = append(open, b)
+			}
+		}
+		backends = open
+	}
+
+	if policy == nil {
+		policy = &RoundRobinPolicy{pool: s}
+	}
+	return policy.Pick(r, backends)
+}
+
+// HealthCheck logs the current status of every backend in the pool. It is a
+// thin placeholder for the active/passive health checker.
+func (s *ServerPool) HealthCheck() {
+	for _, b := range s.loadBackends() {
+		status := "up"
+		if !b.IsAlive() {
+			status = "down"
+		}
+		log.Printf("health check: %s [%s]", b.URL, status)
+	}
+}
+
+type contextKey string
+
+// DefaultMaxRetries bounds how many backends lb will try before giving up,
+// when the pool has no RetryPolicy of its own.
+const DefaultMaxRetries = 3
+
+// bufferedResponse collects a response in memory so lb can decide whether to
+// retry before anything reaches the real client.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+func (b *bufferedResponse) WriteHeader(status int) { b.status = status }
+
+func (b *bufferedResponse) flush(w http.ResponseWriter) {
+	dst := w.Header()
+	for k, v := range b.header {
+		dst[k] = v
+	}
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
+
+var serverPool ServerPool
+
+// lb is the HTTP handler that fronts the backend pool: it picks a peer,
+// proxies the request, and retries against a different peer on failure,
+// honoring the pool's RetryPolicy for retry limits, hedging, and the retry
+// budget.
+func lb(w http.ResponseWriter, r *http.Request) {
+	policy := serverPool.RetryPolicy()
+	breaker := serverPool.CircuitBreaker()
+	maxRetries := policy.maxRetries()
+
+	start := time.Now()
+	var served *Backend
+	status := http.StatusServiceUnavailable
+	retries := 0
+	defer func() {
+		logAccess(r, served, status, retries, time.Since(start))
+	}()
+
+	body := bufferRequestBody(r)
+	if policy != nil && policy.Budget != nil {
+		policy.Budget.RecordRequest()
+	}
+
+	tried := map[string]bool{}
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		retries = attempt
+		if attempt > 0 && policy != nil && policy.Budget != nil && !policy.Budget.Withdraw() {
+			http.Error(w, "Service not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		req := withRequestBody(r.Clone(r.Context()), body)
+		req = withExcludedBackends(req, tried)
+
+		peer := serverPool.GetNextPeerForRequest(req)
+		if peer == nil {
+			http.Error(w, "Service not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		var resp *bufferedResponse
+		if policy.canHedge(req) {
+			resp, served = serveHedged(peer, req, &serverPool, policy.HedgeAfter, tried)
+		} else {
+			resp, served = newDirectResult(peer, req)
+		}
+		tried[served.ID] = true
+		status = resp.status
+
+		if policy.isRetriableStatus(resp.status) {
+			metrics.IncRetry("backend_error")
+			if breaker != nil {
+				breaker.RecordFailure(served)
+			} else {
+				serverPool.MarkBackendStatus(served.URL, false)
+			}
+			continue
+		}
+
+		if breaker != nil {
+			breaker.RecordSuccess(served)
+		}
+		resp.flush(w)
+		return
+	}
+
+	http.Error(w, "Service not available", http.StatusServiceUnavailable)
+}
+
+// logAccess writes one structured access log entry for a request lb has
+// finished handling. served is nil if no backend could be reached at all.
+func logAccess(r *http.Request, served *Backend, status, retries int, duration time.Duration) {
+	backendID := ""
+	if served != nil {
+		backendID = served.ID
+	}
+	accessLogger.Log(AccessLogEntry{
+		Time:       time.Now(),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		ClientIP:   clientIP(r),
+		Backend:    backendID,
+		Status:     status,
+		Retries:    retries,
+		DurationMS: float64(duration) / float64(time.Millisecond),
+	})
+}
+
+// newDirectResult dispatches req to peer without hedging, returning the
+// same (response, backend) shape serveHedged does so lb can treat both
+// paths uniformly.
+func newDirectResult(peer *Backend, req *http.Request) (*bufferedResponse, *Backend) {
+	return serveOnce(peer, req), peer
+}
+
+func main() {
+	var backendList string
+	var port int
+	var configPath string
+	var adminAddr string
+	var l4Addr string
+	var l4TLSPassthrough bool
+	var maxRetries int
+	var hedgeAfter time.Duration
+	var retryBudgetRatio float64
+	var accessLogFile string
+	var accessLogMaxBytes int64
+	flag.StringVar(&backendList, "backends", "", "comma-separated list of backend URLs")
+	flag.IntVar(&port, "port", 3030, "port to serve on")
+	flag.StringVar(&configPath, "config", "", "path to a YAML/JSON backend config file; watched for changes and hot-reloaded. Overrides -backends")
+	flag.StringVar(&adminAddr, "admin-addr", "", "address for the admin API, including /metrics, (e.g. :9090); disabled when empty")
+	flag.StringVar(&l4Addr, "l4-addr", "", "address for a raw TCP/TLS-passthrough listener (e.g. :3306); disabled when empty")
+	flag.BoolVar(&l4TLSPassthrough, "l4-tls-passthrough", false, "route -l4-addr connections by TLS SNI instead of balancing plain TCP")
+	flag.IntVar(&maxRetries, "max-retries", DefaultMaxRetries, "maximum number of different backends to try per request")
+	flag.DurationVar(&hedgeAfter, "hedge-after", 0, "if non-zero, fire a second request to another backend when the first is slower than this (idempotent methods only); disabled when zero")
+	flag.Float64Var(&retryBudgetRatio, "retry-budget-ratio", 0.2, "cap retry volume to this fraction of baseline request volume")
+	flag.StringVar(&accessLogFile, "access-log-file", "", "path to write JSON access logs to; logs to stdout when empty")
+	flag.Int64Var(&accessLogMaxBytes, "access-log-max-bytes", DefaultAccessLogMaxBytes, "rotate -access-log-file once it exceeds this size")
+	flag.Parse()
+
+	if accessLogFile != "" {
+		logger, err := NewAccessLogger(accessLogFile, accessLogMaxBytes)
+		if err != nil {
+			log.Fatalf("opening access log: %v", err)
+		}
+		accessLogger = logger
+		defer accessLogger.Close()
+	}
+
+	// Wire the health checker into the pool before any backend is added, so
+	// Reconcile and the admin API's POST /admin/backends configure probes
+	// for backends added after startup too, not just the initial set.
+	hc := NewHealthChecker()
+	serverPool.SetHealthChecker(hc)
+
+	var cfg *Config
+	switch {
+	case configPath != "":
+		var err error
+		cfg, err = LoadConfig(configPath)
+		if err != nil {
+			log.Fatalf("loading config %s: %v", configPath, err)
+		}
+		if err := serverPool.Reconcile(cfg.Backends); err != nil {
+			log.Fatalf("applying config %s: %v", configPath, err)
+		}
+	case backendList != "":
+		for _, raw := range strings.Split(backendList, ",") {
+			u, err := url.Parse(strings.TrimSpace(raw))
+			if err != nil {
+				log.Fatalf("invalid backend url %q: %v", raw, err)
+			}
+			b := &Backend{
+				ID:           u.String(),
+				URL:          u,
+				Alive:        true,
+				ReverseProxy: httputil.NewSingleHostReverseProxy(u),
+			}
+			serverPool.AddBackend(b)
+			hc.Configure(b, ProbeConfig{Type: ProbeTCP})
+		}
+	default:
+		log.Fatal("at least one backend is required via -backends or -config")
+	}
+
+	serverPool.SetCircuitBreaker(NewCircuitBreaker(CircuitBreakerConfig{}))
+	serverPool.SetRetryPolicy(&RetryPolicy{
+		MaxRetries: maxRetries,
+		HedgeAfter: hedgeAfter,
+		Budget:     NewRetryBudget(retryBudgetRatio),
+	})
+
+	hc.Start()
+	defer hc.Stop()
+
+	if configPath != "" {
+		watcher, err := NewConfigWatcher(configPath, &serverPool)
+		if err != nil {
+			log.Fatalf("watching config %s: %v", configPath, err)
+		}
+		if err := watcher.Start(); err != nil {
+			log.Fatalf("watching config %s: %v", configPath, err)
+		}
+		defer watcher.Stop()
+	}
+
+	if adminAddr != "" {
+		admin := NewAdminServer(&serverPool)
+		go func() {
+			log.Printf("admin API listening on %s", adminAddr)
+			if err := admin.ListenAndServe(adminAddr); err != nil && err != http.ErrServerClosed {
+				log.Printf("admin API stopped: %v", err)
+			}
+		}()
+	}
+
+	if l4Addr != "" {
+		mode := L4TCP
+		if l4TLSPassthrough {
+			mode = L4TLSPassthrough
+		}
+		l4 := NewTCPBalancer(&serverPool, mode)
+		go func() {
+			log.Printf("l4 balancer listening on %s", l4Addr)
+			if err := l4.ListenAndServe(l4Addr); err != nil {
+				log.Printf("l4 balancer stopped: %v", err)
+			}
+		}()
+	}
+
+	server := &http.Server{
+		Addr:    ":" + strconv.Itoa(port),
+		Handler: http.HandlerFunc(lb),
+	}
+
+	log.Printf("load balancer starting on port %d", port)
+	if err := server.ListenAndServe(); err != nil {
+		log.Fatal(err)
+	}
+}
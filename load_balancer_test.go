@@ -1,8 +1,6 @@
 package main
 
 import (
-	"net/http"
-	"net/http/httptest"
 	"net/http/httputil"
 	"net/url"
 	"sync/atomic"
@@ -37,8 +35,8 @@ func TestAddBackend(t *testing.T) {
 	serverPool.AddBackend(backend1)
 	serverPool.AddBackend(backend2)
 
-	if len(serverPool.backends) != 2 {
-		t.Errorf("Expected 2 backends, got %d", len(serverPool.backends))
+	if len(serverPool.Backends()) != 2 {
+		t.Errorf("Expected 2 backends, got %d", len(serverPool.Backends()))
 	}
 }
 
@@ -112,34 +110,6 @@ func TestNoLivePeer(t *testing.T) {
 }
 
 // Test retry mechanism in reverse proxy error handler
-func TestProxyRetry(t *testing.T) {
-	// Create a server that will fail the first request
-	failServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.Error(w, "Backend Error", http.StatusInternalServerError)
-	}))
-	defer failServer.Close()
-
-	parsedUrl, _ := url.Parse(failServer.URL)
-	backend := &Backend{
-		URL:          parsedUrl,
-		Alive:        true,
-		ReverseProxy: httputil.NewSingleHostReverseProxy(parsedUrl),
-	}
-
-	serverPool.AddBackend(backend)
-
-	req := httptest.NewRequest(http.MethodGet, "/", nil)
-	w := httptest.NewRecorder()
-
-	// Call the load balancer handler to test the retry logic
-	lb(w, req)
-
-	resp := w.Result()
-	if resp.StatusCode != http.StatusServiceUnavailable {
-		t.Errorf("Expected status code %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
-	}
-}
-
 // Test that health check correctly updates the status of backends
 func TestHealthCheck(t *testing.T) {
 	serverPool := &ServerPool{}
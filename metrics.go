@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultDurationBuckets are the histogram bucket upper bounds (seconds)
+// used for lb_request_duration_seconds, chosen to cover a typical reverse
+// proxy's latency range from sub-millisecond to several seconds.
+var defaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// counterVec is a Prometheus-style counter keyed by a label tuple. It
+// deliberately implements only the exposition subset this project needs
+// rather than taking a dependency on a full client library.
+type counterVec struct {
+	mux    sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec() *counterVec {
+	return &counterVec{values: map[string]float64{}}
+}
+
+func (c *counterVec) inc(labels ...string) {
+	c.add(1, labels...)
+}
+
+func (c *counterVec) add(delta float64, labels ...string) {
+	key := labelKey(labels)
+	c.mux.Lock()
+	c.values[key] += delta
+	c.mux.Unlock()
+}
+
+func (c *counterVec) snapshot() map[string]float64 {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	out := make(map[string]float64, len(c.values))
+	for k, v := range c.values {
+		out[k] = v
+	}
+	return out
+}
+
+// gaugeVec is a Prometheus-style gauge keyed by a label tuple.
+type gaugeVec struct {
+	mux    sync.Mutex
+	values map[string]float64
+}
+
+func newGaugeVec() *gaugeVec {
+	return &gaugeVec{values: map[string]float64{}}
+}
+
+func (g *gaugeVec) set(v float64, labels ...string) {
+	key := labelKey(labels)
+	g.mux.Lock()
+	g.values[key] = v
+	g.mux.Unlock()
+}
+
+// reset clears every label value, used when a gauge tracks a set of
+// currently-known entities (e.g. backends) and must forget ones that have
+// since disappeared rather than reporting them forever.
+func (g *gaugeVec) reset() {
+	g.mux.Lock()
+	g.values = map[string]float64{}
+	g.mux.Unlock()
+}
+
+func (g *gaugeVec) snapshot() map[string]float64 {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	out := make(map[string]float64, len(g.values))
+	for k, v := range g.values {
+		out[k] = v
+	}
+	return out
+}
+
+// histogramVec is a Prometheus-style histogram keyed by a label tuple,
+// with a fixed set of cumulative buckets shared by every label value.
+type histogramVec struct {
+	buckets []float64
+
+	mux    sync.Mutex
+	counts map[string][]uint64
+	sums   map[string]float64
+	totals map[string]uint64
+}
+
+func newHistogramVec(buckets []float64) *histogramVec {
+	return &histogramVec{
+		buckets: buckets,
+		counts:  map[string][]uint64{},
+		sums:    map[string]float64{},
+		totals:  map[string]uint64{},
+	}
+}
+
+func (h *histogramVec) observe(v float64, labels ...string) {
+	key := labelKey(labels)
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	counts, ok := h.counts[key]
+	if !ok {
+		counts = make([]uint64, len(h.buckets))
+		h.counts[key] = counts
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			counts[i]++
+		}
+	}
+	h.sums[key] += v
+	h.totals[key]++
+}
+
+func labelKey(labels []string) string {
+	return strings.Join(labels, "\xff")
+}
+
+func splitLabelKey(key string, n int) []string {
+	parts := strings.Split(key, "\xff")
+	if len(parts) != n {
+		return make([]string, n)
+	}
+	return parts
+}
+
+// Metrics is the load balancer's Prometheus metrics registry: request
+// counts and latencies, backend health and connection gauges, health
+// check failures, and retry counts. A single process-wide instance is
+// shared across the proxy handler, the health checker, and the metrics
+// HTTP endpoint.
+type Metrics struct {
+	RequestsTotal            *counterVec   // labels: backend, method, status
+	RequestDurationSeconds   *histogramVec // labels: backend
+	BackendUp                *gaugeVec     // labels: backend
+	BackendActiveConnections *gaugeVec     // labels: backend
+	HealthCheckFailuresTotal *counterVec   // labels: backend
+	RetriesTotal             *counterVec   // labels: reason
+}
+
+// NewMetrics returns an empty Metrics registry.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		RequestsTotal:            newCounterVec(),
+		RequestDurationSeconds:   newHistogramVec(defaultDurationBuckets),
+		BackendUp:                newGaugeVec(),
+		BackendActiveConnections: newGaugeVec(),
+		HealthCheckFailuresTotal: newCounterVec(),
+		RetriesTotal:             newCounterVec(),
+	}
+}
+
+// metrics is the process-wide metrics registry. It has no setup cost, so
+// unlike serverPool it needs no explicit wiring before use.
+var metrics = NewMetrics()
+
+// ObserveRequest records one completed backend request: a requests-total
+// increment labeled by backend/method/status, and a duration observation
+// labeled by backend.
+func (m *Metrics) ObserveRequest(backend, method string, status int, duration float64) {
+	m.RequestsTotal.inc(backend, method, strconv.Itoa(status))
+	m.RequestDurationSeconds.observe(duration, backend)
+}
+
+// SetBackendUp records a backend's current health as 1 (up) or 0 (down).
+func (m *Metrics) SetBackendUp(backend string, up bool) {
+	v := 0.0
+	if up {
+		v = 1
+	}
+	m.BackendUp.set(v, backend)
+}
+
+// SetBackendActiveConnections records a backend's current in-flight
+// request count.
+func (m *Metrics) SetBackendActiveConnections(backend string, n int64) {
+	m.BackendActiveConnections.set(float64(n), backend)
+}
+
+// IncHealthCheckFailure records one failed active health probe against
+// backend.
+func (m *Metrics) IncHealthCheckFailure(backend string) {
+	m.HealthCheckFailuresTotal.inc(backend)
+}
+
+// IncRetry records one retry attempt, labeled by why lb decided to retry
+// (e.g. "backend_error").
+func (m *Metrics) IncRetry(reason string) {
+	m.RetriesTotal.inc(reason)
+}
+
+// refreshBackendGauges syncs BackendUp and BackendActiveConnections to the
+// pool's current backend list, so gauges for removed backends don't linger
+// and newly-added ones show up without waiting for their first request.
+func (m *Metrics) refreshBackendGauges(pool *ServerPool) {
+	m.BackendUp.reset()
+	m.BackendActiveConnections.reset()
+	for _, b := range pool.Backends() {
+		m.SetBackendUp(b.ID, b.IsAlive())
+		m.SetBackendActiveConnections(b.ID, b.ConnectionCount())
+	}
+}
+
+// Handler returns an http.Handler serving the registry in Prometheus text
+// exposition format at GET /metrics. pool is consulted on every scrape so
+// the gauges reflect the backend set as it is right now, including
+// backends added or removed since the last scrape.
+func (m *Metrics) Handler(pool *ServerPool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if pool != nil {
+			m.refreshBackendGauges(pool)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.writeTo(w)
+	})
+}
+
+func (m *Metrics) writeTo(w http.ResponseWriter) {
+	writeCounterVec(w, "lb_requests_total", "Total requests dispatched to a backend.", m.RequestsTotal, []string{"backend", "method", "status"})
+	writeHistogramVec(w, "lb_request_duration_seconds", "Backend request duration in seconds.", m.RequestDurationSeconds, []string{"backend"})
+	writeGaugeVec(w, "lb_backend_up", "Whether lb currently considers a backend healthy (1) or not (0).", m.BackendUp, []string{"backend"})
+	writeGaugeVec(w, "lb_backend_active_connections", "Number of in-flight requests currently being served by a backend.", m.BackendActiveConnections, []string{"backend"})
+	writeCounterVec(w, "lb_health_check_failures_total", "Total failed active health check probes against a backend.", m.HealthCheckFailuresTotal, []string{"backend"})
+	writeCounterVec(w, "lb_retries_total", "Total request retries, labeled by the reason a retry was attempted.", m.RetriesTotal, []string{"reason"})
+}
+
+func writeCounterVec(w http.ResponseWriter, name, help string, c *counterVec, labelNames []string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	values := c.snapshot()
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labelNames, splitLabelKey(key, len(labelNames))), formatValue(values[key]))
+	}
+}
+
+func writeGaugeVec(w http.ResponseWriter, name, help string, g *gaugeVec, labelNames []string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	values := g.snapshot()
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labelNames, splitLabelKey(key, len(labelNames))), formatValue(values[key]))
+	}
+}
+
+func writeHistogramVec(w http.ResponseWriter, name, help string, h *histogramVec, labelNames []string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for _, key := range sortedStringKeys(h.counts) {
+		labels := splitLabelKey(key, len(labelNames))
+		for i, upper := range h.buckets {
+			bucketLabels := append(append([]string{}, labels...), strconv.FormatFloat(upper, 'g', -1, 64))
+			fmt.Fprintf(w, "%s_bucket%s %s\n", name, formatLabels(append(append([]string{}, labelNames...), "le"), bucketLabels), formatValue(float64(h.counts[key][i])))
+		}
+		infLabels := append(append([]string{}, labels...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %s\n", name, formatLabels(append(append([]string{}, labelNames...), "le"), infLabels), formatValue(float64(h.totals[key])))
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, formatLabels(labelNames, labels), formatValue(h.sums[key]))
+		fmt.Fprintf(w, "%s_count%s %s\n", name, formatLabels(labelNames, labels), formatValue(float64(h.totals[key])))
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, n := range names {
+		pairs[i] = fmt.Sprintf("%s=%q", n, values[i])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedStringKeys(m map[string][]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func newMetricsTestBackend(t *testing.T, id string, handler http.HandlerFunc) *Backend {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("parsing test server URL: %v", err)
+	}
+	return &Backend{
+		ID:           id,
+		URL:          u,
+		Alive:        true,
+		ReverseProxy: httputil.NewSingleHostReverseProxy(u),
+	}
+}
+
+// Test that serveOnce records a requests-total increment and a duration
+// observation for the dispatched backend.
+func TestMetricsObserveRequestOnDispatch(t *testing.T) {
+	metrics = NewMetrics()
+	backend := newMetricsTestBackend(t, "metrics-a", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	serveOnce(backend, req)
+	serveOnce(backend, req)
+
+	counts := metrics.RequestsTotal.snapshot()
+	if got := counts[labelKey([]string{"metrics-a", http.MethodGet, "200"})]; got != 2 {
+		t.Errorf("expected 2 requests recorded, got %v", got)
+	}
+
+	hist := metrics.RequestDurationSeconds
+	hist.mux.Lock()
+	defer hist.mux.Unlock()
+	if got := hist.totals[labelKey([]string{"metrics-a"})]; got != 2 {
+		t.Errorf("expected 2 duration observations, got %d", got)
+	}
+}
+
+// Test that lb's retry loop counts a retry once per retriable response.
+func TestMetricsRetriesCountedOnRetriableStatus(t *testing.T) {
+	serverPool = ServerPool{}
+	metrics = NewMetrics()
+	var calls int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			http.Error(w, "fail", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	serverPool.AddBackend(newMetricsTestBackend(t, "metrics-retry-a", handler))
+	serverPool.AddBackend(newMetricsTestBackend(t, "metrics-retry-b", handler))
+	serverPool.SetRetryPolicy(&RetryPolicy{MaxRetries: 2})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	lb(w, req)
+
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Result().StatusCode)
+	}
+	counts := metrics.RetriesTotal.snapshot()
+	if got := counts[labelKey([]string{"backend_error"})]; got != 1 {
+		t.Errorf("expected exactly 1 retry recorded, got %v", got)
+	}
+}
+
+// Test that the /metrics endpoint reflects backend gauges across an
+// add/remove cycle: a removed backend's gauge disappears from the
+// exposition and a newly added one appears without needing traffic first.
+func TestMetricsSurviveBackendAddRemove(t *testing.T) {
+	pool := &ServerPool{}
+	metrics = NewMetrics()
+	a := newMetricsTestBackend(t, "metrics-pool-a", func(w http.ResponseWriter, r *http.Request) {})
+	pool.AddBackend(a)
+
+	handler := metrics.Handler(pool)
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `lb_backend_up{backend="metrics-pool-a"} 1`) {
+		t.Fatalf("expected metrics-pool-a to be reported up, got:\n%s", w.Body.String())
+	}
+
+	pool.RemoveBackend("metrics-pool-a")
+	b := newMetricsTestBackend(t, "metrics-pool-b", func(w http.ResponseWriter, r *http.Request) {})
+	pool.AddBackend(b)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	body := w.Body.String()
+	if strings.Contains(body, "metrics-pool-a") {
+		t.Errorf("expected metrics-pool-a to be gone after removal, got:\n%s", body)
+	}
+	if !strings.Contains(body, `lb_backend_up{backend="metrics-pool-b"} 1`) {
+		t.Errorf("expected metrics-pool-b to be reported up, got:\n%s", body)
+	}
+}
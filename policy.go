@@ -0,0 +1,197 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"hash/fnv"
+)
+
+// BalancingPolicy selects a backend for an incoming request from the set of
+// backends currently registered with a ServerPool. Implementations must be
+// safe for concurrent use.
+type BalancingPolicy interface {
+	// Name identifies the policy, e.g. for logging or config selection.
+	Name() string
+	// Pick returns a backend for req, or nil if none is available. req may
+	// be nil when a peer is requested outside of an HTTP request (tests,
+	// warm-up, etc).
+	Pick(req *http.Request, backends []*Backend) *Backend
+}
+
+// RoundRobinPolicy cycles through backends in order, skipping dead ones.
+// It is the default policy used when a ServerPool has none configured.
+type RoundRobinPolicy struct {
+	pool *ServerPool
+}
+
+// NewRoundRobinPolicy returns a RoundRobinPolicy bound to pool's cursor.
+func NewRoundRobinPolicy(pool *ServerPool) *RoundRobinPolicy {
+	return &RoundRobinPolicy{pool: pool}
+}
+
+func (p *RoundRobinPolicy) Name() string { return "round-robin" }
+
+func (p *RoundRobinPolicy) Pick(_ *http.Request, backends []*Backend) *Backend {
+	n := len(backends)
+	if n == 0 {
+		return nil
+	}
+	for i := 0; i < n; i++ {
+		idx := p.pool.NextIndex() % n
+		if backends[idx].IsAlive() {
+			return backends[idx]
+		}
+	}
+	return nil
+}
+
+// WeightedRoundRobinPolicy implements Nginx's smooth weighted round-robin
+// algorithm: each alive backend's currentWeight is increased by its own
+// weight every pick, the highest is chosen, and that backend's currentWeight
+// is then reduced by the total weight of all alive backends. This spreads
+// traffic proportionally to weight without bursting onto one backend.
+type WeightedRoundRobinPolicy struct{}
+
+func (p *WeightedRoundRobinPolicy) Name() string { return "weighted-round-robin" }
+
+func (p *WeightedRoundRobinPolicy) Pick(_ *http.Request, backends []*Backend) *Backend {
+	var total int
+	var best *Backend
+	var bestWeight int
+
+	for _, b := range backends {
+		if !b.IsAlive() {
+			continue
+		}
+		w := b.Weight
+		if w <= 0 {
+			w = 1
+		}
+		total += w
+		cw := b.addCurrentWeight(w)
+		if best == nil || cw > bestWeight {
+			best = b
+			bestWeight = cw
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	best.addCurrentWeight(-total)
+	return best
+}
+
+// LeastConnectionsPolicy routes to the alive backend with the fewest active
+// connections, breaking ties round-robin.
+type LeastConnectionsPolicy struct {
+	current uint64
+}
+
+func (p *LeastConnectionsPolicy) Name() string { return "least-connections" }
+
+func (p *LeastConnectionsPolicy) Pick(_ *http.Request, backends []*Backend) *Backend {
+	var candidates []*Backend
+	min := int64(-1)
+
+	for _, b := range backends {
+		if !b.IsAlive() {
+			continue
+		}
+		c := b.ConnectionCount()
+		switch {
+		case min == -1 || c < min:
+			min = c
+			candidates = []*Backend{b}
+		case c == min:
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	idx := int(atomic.AddUint64(&p.current, 1)) % len(candidates)
+	return candidates[idx]
+}
+
+// ConsistentHashPolicy routes requests with the same key to the same backend
+// using a hash ring with virtual nodes, giving session affinity while
+// minimizing remapping when the backend set changes. KeyFunc extracts the
+// affinity key from the request; it defaults to the client IP.
+type ConsistentHashPolicy struct {
+	KeyFunc  func(*http.Request) string
+	Replicas int
+}
+
+func (p *ConsistentHashPolicy) Name() string { return "consistent-hash" }
+
+func (p *ConsistentHashPolicy) Pick(r *http.Request, backends []*Backend) *Backend {
+	alive := make([]*Backend, 0, len(backends))
+	for _, b := range backends {
+		if b.IsAlive() {
+			alive = append(alive, b)
+		}
+	}
+	if len(alive) == 0 {
+		return nil
+	}
+
+	replicas := p.Replicas
+	if replicas <= 0 {
+		replicas = 100
+	}
+
+	type ringEntry struct {
+		hash    uint32
+		backend *Backend
+	}
+	ring := make([]ringEntry, 0, len(alive)*replicas)
+	for _, b := range alive {
+		for i := 0; i < replicas; i++ {
+			ring = append(ring, ringEntry{hash: hashKey(b.URL.String(), i), backend: b})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	target := hashKey(p.key(r), -1)
+	idx := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= target })
+	if idx == len(ring) {
+		idx = 0
+	}
+	return ring[idx].backend
+}
+
+func (p *ConsistentHashPolicy) key(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	if p.KeyFunc != nil {
+		return p.KeyFunc(r)
+	}
+	return clientIP(r)
+}
+
+func hashKey(key string, replica int) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	if replica >= 0 {
+		h.Write([]byte{byte(replica), byte(replica >> 8)})
+	}
+	return h.Sum32()
+}
+
+// clientIP extracts the caller's address for use as a hash key, preferring
+// a forwarded-for header if present.
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		return strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+func newPolicyTestBackend(t *testing.T, rawUrl string, weight int) *Backend {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		t.Fatalf("failed to parse url: %v", err)
+	}
+	return &Backend{URL: u, Alive: true, Weight: weight}
+}
+
+// Test that weighted round-robin distributes picks proportionally to weight.
+func TestWeightedRoundRobinDistribution(t *testing.T) {
+	b1 := newPolicyTestBackend(t, "http://localhost:4001", 5)
+	b2 := newPolicyTestBackend(t, "http://localhost:4002", 1)
+	backends := []*Backend{b1, b2}
+
+	policy := &WeightedRoundRobinPolicy{}
+	const rounds = 600
+	counts := map[*Backend]int{}
+	for i := 0; i < rounds; i++ {
+		peer := policy.Pick(nil, backends)
+		if peer == nil {
+			t.Fatal("expected a peer, got nil")
+		}
+		counts[peer]++
+	}
+
+	got := float64(counts[b1]) / float64(counts[b2])
+	want := 5.0
+	if got < want-0.5 || got > want+0.5 {
+		t.Errorf("expected roughly 5:1 distribution, got %d:%d (%.2f)", counts[b1], counts[b2], got)
+	}
+}
+
+// Test that least-connections sends concurrent increments/decrements to a
+// consistent minimum without racing (run with -race to catch data races).
+func TestLeastConnectionsConcurrentSafety(t *testing.T) {
+	b1 := newPolicyTestBackend(t, "http://localhost:4003", 0)
+	b2 := newPolicyTestBackend(t, "http://localhost:4004", 0)
+	backends := []*Backend{b1, b2}
+	policy := &LeastConnectionsPolicy{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			peer := policy.Pick(nil, backends)
+			if peer == nil {
+				return
+			}
+			peer.IncrementConnections(1)
+			peer.IncrementConnections(-1)
+		}()
+	}
+	wg.Wait()
+
+	if b1.ConnectionCount() != 0 || b2.ConnectionCount() != 0 {
+		t.Errorf("expected connection counts to return to 0, got %d and %d", b1.ConnectionCount(), b2.ConnectionCount())
+	}
+}
+
+// Test that least-connections prefers the backend with fewer active
+// connections.
+func TestLeastConnectionsPrefersIdle(t *testing.T) {
+	b1 := newPolicyTestBackend(t, "http://localhost:4005", 0)
+	b2 := newPolicyTestBackend(t, "http://localhost:4006", 0)
+	b1.IncrementConnections(3)
+
+	policy := &LeastConnectionsPolicy{}
+	peer := policy.Pick(nil, []*Backend{b1, b2})
+	if peer != b2 {
+		t.Errorf("expected the idle backend to be picked, got %v", peer.URL)
+	}
+}
+
+// Test that every policy falls back to skipping a dead backend rather than
+// returning it.
+func TestPoliciesSkipDeadBackends(t *testing.T) {
+	dead := newPolicyTestBackend(t, "http://localhost:4007", 1)
+	dead.SetAlive(false)
+	alive := newPolicyTestBackend(t, "http://localhost:4008", 1)
+	backends := []*Backend{dead, alive}
+
+	pool := &ServerPool{}
+	pool.AddBackend(dead)
+	pool.AddBackend(alive)
+	req := httptest.NewRequest("GET", "/", nil)
+
+	policies := []BalancingPolicy{
+		NewRoundRobinPolicy(pool),
+		&WeightedRoundRobinPolicy{},
+		&LeastConnectionsPolicy{},
+		&ConsistentHashPolicy{},
+	}
+	for _, policy := range policies {
+		peer := policy.Pick(req, backends)
+		if peer != alive {
+			t.Errorf("%s: expected fallback to the alive backend, got %v", policy.Name(), peer)
+		}
+	}
+}
+
+// Test that a policy returns nil rather than a dead backend when all
+// backends are down.
+func TestPoliciesReturnNilWhenAllDead(t *testing.T) {
+	b1 := newPolicyTestBackend(t, "http://localhost:4009", 1)
+	b2 := newPolicyTestBackend(t, "http://localhost:4010", 1)
+	b1.SetAlive(false)
+	b2.SetAlive(false)
+	backends := []*Backend{b1, b2}
+
+	pool := &ServerPool{}
+	pool.AddBackend(b1)
+	pool.AddBackend(b2)
+	policies := []BalancingPolicy{
+		NewRoundRobinPolicy(pool),
+		&WeightedRoundRobinPolicy{},
+		&LeastConnectionsPolicy{},
+		&ConsistentHashPolicy{},
+	}
+	for _, policy := range policies {
+		if peer := policy.Pick(nil, backends); peer != nil {
+			t.Errorf("%s: expected nil, got %v", policy.Name(), peer)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// excludedBackendsContextKey stashes the set of backend IDs already tried
+// for a request on its context, so GetNextPeerForRequest can skip them on
+// retry instead of potentially re-dispatching to the same dead backend.
+const excludedBackendsContextKey contextKey = "excluded-backends"
+
+// hedgingAllowedContextKey opts a non-idempotent request into hedging; set
+// it with AllowHedging for routes the operator knows are safe to retry in
+// parallel despite not being GET/HEAD/OPTIONS.
+const hedgingAllowedContextKey contextKey = "hedging-allowed"
+
+// withExcludedBackends returns a shallow copy of r carrying excluded as the
+// set of backend IDs GetNextPeerForRequest should skip.
+func withExcludedBackends(r *http.Request, excluded map[string]bool) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), excludedBackendsContextKey, excluded))
+}
+
+// excludedBackendsFromContext reads the exclusion set stashed by
+// withExcludedBackends, or nil if none was set.
+func excludedBackendsFromContext(r *http.Request) map[string]bool {
+	if r == nil {
+		return nil
+	}
+	excluded, _ := r.Context().Value(excludedBackendsContextKey).(map[string]bool)
+	return excluded
+}
+
+// AllowHedging marks r as eligible for request hedging even though its
+// method isn't one of the normally-safe idempotent ones (GET/HEAD/OPTIONS).
+// Use this for routes that are known to be safe to retry in parallel, e.g.
+// an idempotent POST guarded by a client-supplied dedup key.
+func AllowHedging(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), hedgingAllowedContextKey, true))
+}
+
+func hedgingOptedIn(r *http.Request) bool {
+	allowed, _ := r.Context().Value(hedgingAllowedContextKey).(bool)
+	return allowed
+}
+
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// RetryPolicy controls how lb retries a failed request: how many
+// different backends it's willing to try, which response statuses count
+// as retriable, and the hedging and retry-budget knobs layered on top.
+type RetryPolicy struct {
+	// MaxRetries bounds how many backends lb will try before giving up.
+	// Zero means DefaultMaxRetries.
+	MaxRetries int
+	// RetriableStatuses lists the HTTP statuses that trigger a retry
+	// against a different backend. A nil map falls back to "5xx".
+	RetriableStatuses map[int]bool
+
+	// HedgeAfter, if non-zero, fires a second request to a different
+	// backend if the first hasn't responded within this long, using
+	// whichever response arrives first. Only applies to idempotent
+	// requests (GET/HEAD/OPTIONS) unless AllowHedging opted the request
+	// in explicitly.
+	HedgeAfter time.Duration
+
+	// Budget caps how much retry traffic a surge of failures can
+	// generate, as a fraction of baseline request volume. Nil disables
+	// budget enforcement (retries are bounded only by MaxRetries).
+	Budget *RetryBudget
+}
+
+func (p *RetryPolicy) maxRetries() int {
+	if p == nil || p.MaxRetries <= 0 {
+		return DefaultMaxRetries
+	}
+	return p.MaxRetries
+}
+
+func (p *RetryPolicy) isRetriableStatus(code int) bool {
+	if p != nil && p.RetriableStatuses != nil {
+		return p.RetriableStatuses[code]
+	}
+	return code >= http.StatusInternalServerError
+}
+
+func (p *RetryPolicy) canHedge(r *http.Request) bool {
+	if p == nil || p.HedgeAfter <= 0 {
+		return false
+	}
+	return isIdempotentMethod(r.Method) || hedgingOptedIn(r)
+}
+
+// RetryBudget is a token-bucket limiter on retry volume: every accepted
+// request deposits a fraction of a token (ratio), every retry attempt
+// withdraws a whole one, and withdrawal fails once the bucket is dry. With
+// the default ratio of 0.2, retries are bounded to roughly 20% of baseline
+// traffic — a surge of backend failures can no longer amplify request
+// volume without limit. MaxTokens provides a small burst allowance so the
+// first few failures after startup aren't instantly starved.
+type RetryBudget struct {
+	mux    sync.Mutex
+	tokens float64
+
+	MaxTokens float64
+	Ratio     float64
+}
+
+// NewRetryBudget returns a RetryBudget with the given retry ratio (e.g. 0.2
+// for "retries may add at most 20% extra traffic"), applying a default
+// ratio and burst allowance when ratio is non-positive.
+func NewRetryBudget(ratio float64) *RetryBudget {
+	if ratio <= 0 {
+		ratio = 0.2
+	}
+	return &RetryBudget{tokens: 10, MaxTokens: 10, Ratio: ratio}
+}
+
+// RecordRequest deposits credit for one accepted (non-retry) request.
+func (b *RetryBudget) RecordRequest() {
+	b.mux.Lock()
+	b.tokens += b.Ratio
+	if b.tokens > b.MaxTokens {
+		b.tokens = b.MaxTokens
+	}
+	b.mux.Unlock()
+}
+
+// Withdraw spends one token for a retry attempt, reporting whether the
+// budget allowed it.
+func (b *RetryBudget) Withdraw() bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// serveOnce dispatches req to peer and returns the buffered response.
+func serveOnce(peer *Backend, req *http.Request) *bufferedResponse {
+	peer.Stats.mux.Lock()
+	peer.Stats.TotalRequests++
+	peer.Stats.mux.Unlock()
+
+	resp := newBufferedResponse()
+	peer.IncrementConnections(1)
+	start := time.Now()
+	peer.serveHTTP(resp, req)
+	duration := time.Since(start).Seconds()
+	peer.IncrementConnections(-1)
+
+	metrics.ObserveRequest(peer.ID, req.Method, resp.status, duration)
+	return resp
+}
+
+// serveHedged dispatches req to primary, and if it hasn't responded within
+// hedgeAfter, fires a second request to a different backend chosen via
+// pool (excluding primary and anything already in tried). Whichever
+// response arrives first wins; the other request's context is cancelled
+// once serveHedged returns, aborting it in flight.
+func serveHedged(primary *Backend, req *http.Request, pool *ServerPool, hedgeAfter time.Duration, tried map[string]bool) (*bufferedResponse, *Backend) {
+	type result struct {
+		resp *bufferedResponse
+		peer *Backend
+	}
+	results := make(chan result, 2)
+
+	primaryCtx, cancelPrimary := context.WithCancel(req.Context())
+	defer cancelPrimary()
+	go func() {
+		results <- result{serveOnce(primary, req.Clone(primaryCtx)), primary}
+	}()
+
+	timer := time.NewTimer(hedgeAfter)
+	defer timer.Stop()
+
+	select {
+	case r := <-results:
+		return r.resp, r.peer
+	case <-timer.C:
+	}
+
+	excluded := make(map[string]bool, len(tried)+1)
+	for id := range tried {
+		excluded[id] = true
+	}
+	excluded[primary.ID] = true
+	hedgePeer := pool.GetNextPeerForRequest(withExcludedBackends(req, excluded))
+	if hedgePeer == nil {
+		r := <-results
+		return r.resp, r.peer
+	}
+
+	hedgeCtx, cancelHedge := context.WithCancel(req.Context())
+	defer cancelHedge()
+	go func() {
+		results <- result{serveOnce(hedgePeer, req.Clone(hedgeCtx)), hedgePeer}
+	}()
+
+	r := <-results
+	return r.resp, r.peer
+}
+
+// bufferRequestBody reads r.Body fully into memory (if present) and
+// returns the bytes so each retry/hedge attempt can get its own fresh
+// io.Reader, since the original body can only be read once.
+func bufferRequestBody(r *http.Request) []byte {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil
+	}
+	return body
+}
+
+func withRequestBody(r *http.Request, body []byte) *http.Request {
+	if body == nil {
+		return r
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return r
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestBackend(t *testing.T, id string, handler http.HandlerFunc) *Backend {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("parsing test server URL: %v", err)
+	}
+	return &Backend{
+		ID:           id,
+		URL:          u,
+		Alive:        true,
+		ReverseProxy: httputil.NewSingleHostReverseProxy(u),
+	}
+}
+
+// Test that lb never retries the same backend twice for one request: with
+// two always-failing backends and MaxRetries of 2, each should be tried
+// exactly once before giving up.
+func TestLBRetryExcludesTriedBackends(t *testing.T) {
+	serverPool = ServerPool{}
+	var hitsA, hitsB int32
+	serverPool.AddBackend(newTestBackend(t, "retry-a", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hitsA, 1)
+		http.Error(w, "fail", http.StatusInternalServerError)
+	}))
+	serverPool.AddBackend(newTestBackend(t, "retry-b", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hitsB, 1)
+		http.Error(w, "fail", http.StatusInternalServerError)
+	}))
+	serverPool.SetRetryPolicy(&RetryPolicy{MaxRetries: 2})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	lb(w, req)
+
+	if w.Result().StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", w.Result().StatusCode)
+	}
+	if hitsA != 1 || hitsB != 1 {
+		t.Errorf("expected each backend hit exactly once, got a=%d b=%d", hitsA, hitsB)
+	}
+}
+
+// Test that lb retries a failed request against a different backend and
+// returns its successful response, instead of giving up after the first
+// backend's error.
+func TestLBRetriesFailedBackendAndSucceeds(t *testing.T) {
+	serverPool = ServerPool{}
+	// Both backends share one handler so the assertions below don't depend
+	// on which one round-robin happens to try first: whichever is dispatched
+	// to first fails and gets excluded, and the retry against the other one
+	// succeeds.
+	var calls int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			http.Error(w, "fail", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	}
+	serverPool.AddBackend(newTestBackend(t, "retry-a", handler))
+	serverPool.AddBackend(newTestBackend(t, "retry-b", handler))
+	serverPool.SetRetryPolicy(&RetryPolicy{MaxRetries: 2})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	lb(w, req)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if calls != 2 {
+		t.Errorf("expected the first backend to fail and the retry to succeed against the other, got %d calls", calls)
+	}
+}
+
+// Test that once the retry budget is exhausted, lb stops retrying even
+// though attempts remain under MaxRetries.
+func TestLBRetryBudgetExhaustion(t *testing.T) {
+	serverPool = ServerPool{}
+	var hits int32
+	serverPool.AddBackend(newTestBackend(t, "budget-a", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		http.Error(w, "fail", http.StatusInternalServerError)
+	}))
+	budget := &RetryBudget{MaxTokens: 10, Ratio: 0.2}
+	serverPool.SetRetryPolicy(&RetryPolicy{MaxRetries: 5, Budget: budget})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	lb(w, req)
+
+	if w.Result().StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", w.Result().StatusCode)
+	}
+	if hits != 1 {
+		t.Errorf("expected exactly 1 attempt before the budget was exhausted, got %d", hits)
+	}
+}
+
+// Test that hedging returns the faster of two backends' responses and
+// cancels the slower one.
+func TestLBHedgingUsesFasterBackend(t *testing.T) {
+	serverPool = ServerPool{}
+	slowCancelled := make(chan struct{}, 1)
+	slow := newTestBackend(t, "hedge-slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+			w.Write([]byte("slow"))
+		case <-r.Context().Done():
+			slowCancelled <- struct{}{}
+		}
+	})
+	fast := newTestBackend(t, "hedge-fast", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	})
+	// RoundRobinPolicy's first pick lands on index 1, so add fast before
+	// slow to guarantee slow is dispatched as the primary.
+	serverPool.AddBackend(fast)
+	serverPool.AddBackend(slow)
+	serverPool.SetPolicy(NewRoundRobinPolicy(&serverPool))
+	serverPool.SetRetryPolicy(&RetryPolicy{MaxRetries: 1, HedgeAfter: 20 * time.Millisecond})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	lb(w, req)
+
+	if got := w.Body.String(); got != "fast" {
+		t.Errorf("expected the fast backend's response, got %q", got)
+	}
+
+	select {
+	case <-slowCancelled:
+	case <-time.After(time.Second):
+		t.Error("expected the slow backend's request to be cancelled once the fast one won")
+	}
+}
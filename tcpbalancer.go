@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// L4Mode selects how a TCPBalancer interprets the connections it accepts.
+type L4Mode int
+
+const (
+	// L4TCP balances plain TCP connections, dialing a backend as soon as a
+	// connection is accepted.
+	L4TCP L4Mode = iota
+	// L4TLSPassthrough peeks the ClientHello's SNI server name to pick a
+	// backend without terminating TLS, then forwards the raw bytes
+	// (ClientHello included) for the backend to negotiate TLS itself.
+	L4TLSPassthrough
+)
+
+// TCPBalancer load-balances raw TCP connections across a ServerPool, using
+// the same BalancingPolicy, health checking, and circuit-breaker machinery
+// as the HTTP (L7) path so operators get one coherent pool model for both.
+type TCPBalancer struct {
+	pool        *ServerPool
+	mode        L4Mode
+	DialTimeout time.Duration
+}
+
+// NewTCPBalancer returns a TCPBalancer fronting pool in the given mode. In
+// L4TLSPassthrough mode, if pool has no explicit BalancingPolicy set, it's
+// given a ConsistentHashPolicy keyed on the peeked SNI server name so that
+// turning the mode on actually changes routing instead of silently adding
+// ClientHello-peeking overhead to plain round-robin.
+func NewTCPBalancer(pool *ServerPool, mode L4Mode) *TCPBalancer {
+	if mode == L4TLSPassthrough && pool.Policy() == nil {
+		pool.SetPolicy(&ConsistentHashPolicy{KeyFunc: func(r *http.Request) string { return r.Host }})
+	}
+	return &TCPBalancer{pool: pool, mode: mode, DialTimeout: 5 * time.Second}
+}
+
+// ListenAndServe accepts connections on addr and proxies each to a backend
+// until the listener is closed.
+func (t *TCPBalancer) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go t.handle(conn)
+	}
+}
+
+// handle picks a backend for conn, dials it, and bidirectionally copies
+// bytes between the two until either side closes.
+func (t *TCPBalancer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	clientConn, req, err := t.identify(conn)
+	if err != nil {
+		log.Printf("l4: %v", err)
+		return
+	}
+
+	peer := t.pool.GetNextPeerForRequest(req)
+	if peer == nil {
+		log.Printf("l4: no backend available for %s", conn.RemoteAddr())
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", peer.URL.Host, t.DialTimeout)
+	if err != nil {
+		t.pool.MarkBackendStatus(peer.URL, false)
+		log.Printf("l4: dialing backend %s: %v", peer.URL, err)
+		return
+	}
+	defer upstream.Close()
+
+	peer.IncrementConnections(1)
+	defer peer.IncrementConnections(-1)
+
+	proxyConns(clientConn, upstream)
+}
+
+// identify inspects the incoming connection enough to pick a backend: in
+// plain TCP mode that's just the client address (for consistent-hash
+// affinity); in TLS passthrough mode it also peeks the ClientHello's SNI
+// server name. It returns a conn that still has any peeked bytes available
+// to read, so nothing is lost once proxying begins.
+func (t *TCPBalancer) identify(conn net.Conn) (net.Conn, *http.Request, error) {
+	req := &http.Request{RemoteAddr: conn.RemoteAddr().String()}
+	if t.mode != L4TLSPassthrough {
+		return conn, req, nil
+	}
+
+	br := bufio.NewReaderSize(conn, 8192)
+	sni, err := peekClientHelloSNI(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Host = sni
+	return &peekedConn{Conn: conn, br: br}, req, nil
+}
+
+// peekedConn is a net.Conn whose reads are served from a bufio.Reader that
+// already buffered some bytes (the peeked ClientHello), falling through to
+// the underlying connection once that's drained.
+type peekedConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (p *peekedConn) Read(b []byte) (int, error) { return p.br.Read(b) }
+
+// CloseWrite is promoted explicitly since it isn't part of the net.Conn
+// interface embedded above, and proxyConns' half-close detection needs it.
+func (p *peekedConn) CloseWrite() error {
+	if cw, ok := p.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// proxyConns copies bytes bidirectionally between a and b until both
+// directions finish, propagating half-closes (TCP FIN in one direction
+// only) by calling CloseWrite on the destination once its source is
+// drained, rather than closing the whole connection.
+func proxyConns(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		copyHalf(b, a)
+	}()
+	go func() {
+		defer wg.Done()
+		copyHalf(a, b)
+	}()
+	wg.Wait()
+}
+
+func copyHalf(dst, src net.Conn) {
+	io.Copy(dst, src)
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+}
+
+var errShortClientHello = errors.New("l4: client hello too short to parse")
+
+// peekClientHelloSNI peeks (without consuming) the first TLS record off br
+// and extracts the SNI server name from its ClientHello, if present. An
+// empty string with a nil error means the record parsed fine but carried
+// no server_name extension.
+func peekClientHelloSNI(br *bufio.Reader) (string, error) {
+	head, err := br.Peek(5)
+	if err != nil {
+		return "", err
+	}
+	const tlsHandshakeContentType = 0x16
+	if head[0] != tlsHandshakeContentType {
+		return "", errors.New("l4: not a TLS handshake record")
+	}
+	recordLen := int(head[3])<<8 | int(head[4])
+
+	record, err := br.Peek(5 + recordLen)
+	if err != nil {
+		return "", err
+	}
+	return parseClientHelloServerName(record[5:])
+}
+
+// parseClientHelloServerName parses a ClientHello handshake message
+// (RFC 8446 section 4.1.2) far enough to extract the server_name extension
+// (RFC 6066 section 3), ignoring everything it doesn't need.
+func parseClientHelloServerName(msg []byte) (string, error) {
+	if len(msg) < 4 || msg[0] != 0x01 {
+		return "", errors.New("l4: not a ClientHello")
+	}
+	handshakeLen := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+	if 4+handshakeLen > len(msg) {
+		return "", errors.New("l4: client hello spans multiple TLS records")
+	}
+	body := msg[4 : 4+handshakeLen]
+
+	pos := 2 + 32 // client_version + random
+	if pos+1 > len(body) {
+		return "", errShortClientHello
+	}
+	pos += 1 + int(body[pos]) // session_id
+
+	if pos+2 > len(body) {
+		return "", errShortClientHello
+	}
+	pos += 2 + (int(body[pos])<<8 | int(body[pos+1])) // cipher_suites
+
+	if pos+1 > len(body) {
+		return "", errShortClientHello
+	}
+	pos += 1 + int(body[pos]) // compression_methods
+
+	if pos+2 > len(body) {
+		// No extensions present, so no SNI; not malformed.
+		return "", nil
+	}
+	extTotal := int(body[pos])<<8 | int(body[pos+1])
+	pos += 2
+	end := pos + extTotal
+	if end > len(body) {
+		end = len(body)
+	}
+
+	const serverNameExtType = 0
+	for pos+4 <= end {
+		extType := int(body[pos])<<8 | int(body[pos+1])
+		extLen := int(body[pos+2])<<8 | int(body[pos+3])
+		pos += 4
+		if pos+extLen > end {
+			break
+		}
+		if extType == serverNameExtType {
+			return parseServerNameExtension(body[pos : pos+extLen])
+		}
+		pos += extLen
+	}
+	return "", nil
+}
+
+// parseServerNameExtension parses a server_name extension body and returns
+// its host_name entry, if present.
+func parseServerNameExtension(ext []byte) (string, error) {
+	if len(ext) < 2 {
+		return "", errShortClientHello
+	}
+	listLen := int(ext[0])<<8 | int(ext[1])
+	pos := 2
+	end := pos + listLen
+	if end > len(ext) {
+		end = len(ext)
+	}
+
+	const hostNameType = 0
+	for pos+3 <= end {
+		nameType := ext[pos]
+		nameLen := int(ext[pos+1])<<8 | int(ext[pos+2])
+		pos += 3
+		if pos+nameLen > end {
+			break
+		}
+		if nameType == hostNameType {
+			return string(ext[pos : pos+nameLen]), nil
+		}
+		pos += nameLen
+	}
+	return "", nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildClientHello constructs a minimal, well-formed TLS 1.2 ClientHello
+// record carrying a single server_name extension, for exercising
+// peekClientHelloSNI without a real TLS client.
+func buildClientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+
+	hostBytes := []byte(serverName)
+	nameEntry := append([]byte{0x00, byte(len(hostBytes) >> 8), byte(len(hostBytes))}, hostBytes...)
+	serverNameList := append([]byte{byte(len(nameEntry) >> 8), byte(len(nameEntry))}, nameEntry...)
+	sniExt := append([]byte{0x00, 0x00, byte(len(serverNameList) >> 8), byte(len(serverNameList))}, serverNameList...)
+
+	var body bytes.Buffer
+	body.Write([]byte{0x03, 0x03})             // client_version
+	body.Write(make([]byte, 32))               // random
+	body.WriteByte(0x00)                       // session_id length
+	body.Write([]byte{0x00, 0x02, 0x00, 0x2f}) // cipher_suites (one suite)
+	body.Write([]byte{0x01, 0x00})             // compression_methods (null)
+	extLen := len(sniExt)
+	body.Write([]byte{byte(extLen >> 8), byte(extLen)})
+	body.Write(sniExt)
+
+	handshake := body.Bytes()
+	hsLen := len(handshake)
+	msg := append([]byte{0x01, byte(hsLen >> 16), byte(hsLen >> 8), byte(hsLen)}, handshake...)
+
+	recLen := len(msg)
+	record := append([]byte{0x16, 0x03, 0x01, byte(recLen >> 8), byte(recLen)}, msg...)
+	return record
+}
+
+// Test that peekClientHelloSNI extracts the server_name extension from a
+// synthetic ClientHello.
+func TestPeekClientHelloSNI(t *testing.T) {
+	record := buildClientHello(t, "example.internal")
+	br := bufio.NewReader(bytes.NewReader(record))
+
+	sni, err := peekClientHelloSNI(br)
+	if err != nil {
+		t.Fatalf("peekClientHelloSNI: %v", err)
+	}
+	if sni != "example.internal" {
+		t.Errorf("expected SNI %q, got %q", "example.internal", sni)
+	}
+
+	// Peeking must not have consumed the bytes: the full record should
+	// still be readable afterwards.
+	replayed, err := io.ReadAll(br)
+	if err != nil {
+		t.Fatalf("reading after peek: %v", err)
+	}
+	if !bytes.Equal(replayed, record) {
+		t.Error("expected peek to leave the record intact for later reading")
+	}
+}
+
+// Test that peekClientHelloSNI rejects non-TLS-handshake input instead of
+// panicking on malformed data.
+func TestPeekClientHelloSNINotTLS(t *testing.T) {
+	br := bufio.NewReader(bytes.NewReader([]byte("GET / HTTP/1.1\r\n\r\n")))
+	if _, err := peekClientHelloSNI(br); err == nil {
+		t.Error("expected an error for non-TLS input")
+	}
+}
+
+// Test that TCPBalancer in plain TCP mode proxies bytes in both
+// directions between the client and the chosen backend.
+func TestTCPBalancerProxiesBytes(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	defer upstream.Close()
+
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 5)
+		io.ReadFull(conn, buf)
+		conn.Write([]byte("world"))
+	}()
+
+	pool := &ServerPool{}
+	u, _ := url.Parse("tcp://" + upstream.Addr().String())
+	pool.AddBackend(&Backend{ID: "u", URL: u, Alive: true})
+
+	balancer := NewTCPBalancer(pool, L4TCP)
+
+	balancerLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := balancerLn.Accept()
+			if err != nil {
+				return
+			}
+			go balancer.handle(conn)
+		}
+	}()
+	defer balancerLn.Close()
+
+	client, err := net.DialTimeout("tcp", balancerLn.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("dialing balancer: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatalf("writing to balancer: %v", err)
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("reading from balancer: %v", err)
+	}
+	if string(buf) != "world" {
+		t.Errorf("expected %q, got %q", "world", buf)
+	}
+}
+
+// echoBackendID starts a listener that, for every connection, writes back a
+// fixed id and then echoes whatever it reads, so a test can tell which
+// backend a proxied connection landed on.
+func echoBackendID(t *testing.T, id string) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte(id + "\n"))
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	return ln
+}
+
+// Test that, in L4TLSPassthrough mode, connections carrying the same SNI
+// server name consistently land on the same backend, and that NewTCPBalancer
+// wired up a ConsistentHashPolicy automatically since the pool had none.
+func TestTCPBalancerTLSPassthroughRoutesBySNI(t *testing.T) {
+	backendA := echoBackendID(t, "a")
+	defer backendA.Close()
+	backendB := echoBackendID(t, "b")
+	defer backendB.Close()
+
+	pool := &ServerPool{}
+	uA, _ := url.Parse("tcp://" + backendA.Addr().String())
+	uB, _ := url.Parse("tcp://" + backendB.Addr().String())
+	pool.AddBackend(&Backend{ID: "a", URL: uA, Alive: true})
+	pool.AddBackend(&Backend{ID: "b", URL: uB, Alive: true})
+
+	balancer := NewTCPBalancer(pool, L4TLSPassthrough)
+
+	balancerLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	defer balancerLn.Close()
+	go func() {
+		for {
+			conn, err := balancerLn.Accept()
+			if err != nil {
+				return
+			}
+			go balancer.handle(conn)
+		}
+	}()
+
+	dialAndReadID := func(sni string) string {
+		t.Helper()
+		conn, err := net.DialTimeout("tcp", balancerLn.Addr().String(), time.Second)
+		if err != nil {
+			t.Fatalf("dialing balancer: %v", err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write(buildClientHello(t, sni)); err != nil {
+			t.Fatalf("writing client hello: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading backend id: %v", err)
+		}
+		return strings.TrimSpace(line)
+	}
+
+	first := dialAndReadID("same.example.internal")
+	for i := 0; i < 5; i++ {
+		if got := dialAndReadID("same.example.internal"); got != first {
+			t.Fatalf("expected every connection with the same SNI to land on backend %q, got %q", first, got)
+		}
+	}
+}